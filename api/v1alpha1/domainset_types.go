@@ -0,0 +1,157 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionReady reports whether the networks of the set are resolved and written to the networkset
+const ConditionReady = "Ready"
+
+const (
+	// ReasonResolved is the reason of the Ready condition when all domains and sources are resolved
+	ReasonResolved = "Resolved"
+	// ReasonResolveFailed is the reason of the Ready condition when a domain or source is not resolved
+	ReasonResolveFailed = "ResolveFailed"
+	// ReasonTooFewAddresses is the reason of the Ready condition when fewer than minAddresses are resolved
+	ReasonTooFewAddresses = "TooFewAddresses"
+	// ReasonNetworkSetFailed is the reason of the Ready condition when the networkset is not written
+	ReasonNetworkSetFailed = "NetworkSetFailed"
+	// ReasonDomainNotAllowed is the reason of the Ready condition when a domain or source is not allowed in the namespace
+	ReasonDomainNotAllowed = "DomainNotAllowed"
+)
+
+// DomainSetSource is the value resolved by the resolver registered for the selector label
+type DomainSetSource struct {
+	// Resolver is the selector label of the resolver, for example DNS_RESOLVER or the label of --http-resolver
+	// +kubebuilder:validation:MinLength=1
+	Resolver string `json:"resolver"`
+
+	// Value is resolved by the resolver, for example the domain name or the file name
+	// +kubebuilder:validation:MinLength=1
+	Value string `json:"value"`
+}
+
+// DomainSetSpec defines the desired state of DomainSet and GlobalDomainSet
+type DomainSetSpec struct {
+	// Domains are resolved by DNS
+	// +optional
+	Domains []string `json:"domains,omitempty"`
+
+	// Sources are resolved by the resolvers of the controller
+	// +optional
+	Sources []DomainSetSource `json:"sources,omitempty"`
+
+	// AddressFamily selects networks of IPv4, IPv6 or both families, the controller default is used if empty
+	// +kubebuilder:validation:Enum=IPv4;IPv6;Dual
+	// +optional
+	AddressFamily string `json:"addressFamily,omitempty"`
+
+	// RefreshInterval is the refresh interval of the resolvers without TTL, the controller default is used if empty
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// Accumulate keeps the networks for the retention window after they were resolved last time
+	// +optional
+	Accumulate *metav1.Duration `json:"accumulate,omitempty"`
+
+	// MinAddresses is the minimal number of the resolved networks, the networkset keeps
+	// the last known good networks when fewer networks are resolved
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinAddresses int32 `json:"minAddresses,omitempty"`
+
+	// MaxAddresses limits the number of the accumulated networks, the most recently seen are kept
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxAddresses int32 `json:"maxAddresses,omitempty"`
+}
+
+// DomainSetStatus defines the observed state of DomainSet and GlobalDomainSet
+type DomainSetStatus struct {
+	// Addresses are the networks of the networkset
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+
+	// LastRefreshTime is the time the resolve changed the addresses or the Ready condition last time
+	// +optional
+	LastRefreshTime *metav1.Time `json:"lastRefreshTime,omitempty"`
+
+	// ObservedGeneration is the generation of the spec written to the networkset
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the set
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Last Refresh",type=date,JSONPath=`.status.lastRefreshTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DomainSet is resolved to the NetworkSet with the same name and namespace
+type DomainSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DomainSetSpec   `json:"spec,omitempty"`
+	Status DomainSetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DomainSetList contains a list of DomainSet
+type DomainSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DomainSet `json:"items"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Last Refresh",type=date,JSONPath=`.status.lastRefreshTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// GlobalDomainSet is resolved to the GlobalNetworkSet with the same name
+type GlobalDomainSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DomainSetSpec   `json:"spec,omitempty"`
+	Status DomainSetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GlobalDomainSetList contains a list of GlobalDomainSet
+type GlobalDomainSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GlobalDomainSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DomainSet{}, &DomainSetList{}, &GlobalDomainSet{}, &GlobalDomainSetList{})
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/controller"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// describe prints the resolve status, the addresses and the history of the networkset
+func describe(args []string) error {
+	o := &options{}
+	flags := newFlagSet("describe", "SET",
+		"Show the domain, the result of the last resolve, the addresses with the time they were resolved last time "+
+			"and the events of the networkset.", o)
+	arguments, err := o.parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(arguments) != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one networkset, got %d arguments", len(arguments))
+	}
+
+	ctx := context.Background()
+	set, err := o.getSet(ctx, arguments[0])
+	if err != nil {
+		return err
+	}
+	events, err := o.setEvents(ctx, set)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", set.Object.GetName())
+	fmt.Fprintf(w, "Namespace:\t%s\n", namespaceOf(set.Object))
+	fmt.Fprintf(w, "Kind:\t%s\n", setKind(set.Object))
+	fmt.Fprintf(w, "Policy:\t%s\n", policyReference(set))
+	if set.PolicyUID != "" {
+		fmt.Fprintf(w, "Policy UID:\t%s\n", set.PolicyUID)
+	}
+	fmt.Fprintf(w, "Label:\t%s\n", set.Label)
+	fmt.Fprintf(w, "Domain:\t%s\n", set.Domain)
+	fmt.Fprintf(w, "Source:\t%s\n", set.Source)
+	fmt.Fprintf(w, "Last Resolve:\t%s\n", formatTime(set.LastResolve, now))
+	fmt.Fprintf(w, "Last Change:\t%s\n", formatTime(set.LastChange, now))
+	fmt.Fprintf(w, "Resolved Count:\t%d\n", set.ResolvedCount)
+	if !set.FailedSince.IsZero() {
+		fmt.Fprintf(w, "Resolve Failed Since:\t%s\n", formatTime(set.FailedSince, now))
+		fmt.Fprintf(w, "Last Error:\t%s\n", set.LastError)
+	}
+	fmt.Fprintf(w, "Manual Override:\t%t\n", set.ManualOverride)
+	fmt.Fprintf(w, "Refresh Requested:\t%t\n", set.RefreshRequested)
+
+	fmt.Fprintf(w, "Addresses:\t%d\n", len(set.Nets))
+	if len(set.LastSeen) > 0 {
+		fmt.Fprintln(w, "  Network\tLast Seen")
+		fmt.Fprintln(w, "  -------\t---------")
+	}
+	for _, network := range set.Nets {
+		if seen, ok := set.LastSeen[network]; ok {
+			fmt.Fprintf(w, "  %s\t%s\n", network, formatTime(seen, now))
+		} else {
+			fmt.Fprintf(w, "  %s\n", network)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if len(events) == 0 {
+		fmt.Fprintln(w, "Events:\t<none>")
+		return w.Flush()
+	}
+	fmt.Fprintln(w, "Events:")
+	fmt.Fprintln(w, "  Type\tReason\tAge\tFrom\tMessage")
+	fmt.Fprintln(w, "  ----\t------\t---\t----\t-------")
+	for _, event := range events {
+		source := strings.ToLower(event.InvolvedObject.Kind) + "/" + event.InvolvedObject.Name
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", event.Type, event.Reason, age(eventTime(event), now), source, event.Message)
+	}
+	return w.Flush()
+}
+
+// setEvents returns the events of the networkset and the events of its policy about the networkset sorted by time.
+// The events of the globalnetworksets and globalnetworkpolicies are recorded in the default namespace
+func (o *options) setEvents(ctx context.Context, set controller.ManagedSet) ([]corev1.Event, error) {
+	namespace := set.Object.GetNamespace()
+	policyKind := calicov3.KindNetworkPolicy
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+		policyKind = calicov3.KindGlobalNetworkPolicy
+	}
+
+	var events []corev1.Event
+	for _, involved := range []struct {
+		kind string
+		name string
+	}{
+		{kind: setKind(set.Object), name: set.Object.GetName()},
+		{kind: policyKind, name: set.Policy},
+	} {
+		eventList := &corev1.EventList{}
+		err := o.client.List(ctx, eventList, client.InNamespace(namespace),
+			client.MatchingFields{"involvedObject.kind": involved.kind, "involvedObject.name": involved.name})
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range eventList.Items {
+			// the events of the policy mention the networkset by its name
+			if involved.kind == policyKind && !strings.HasPrefix(event.Message, setKind(set.Object)+" "+set.Object.GetName()+" ") {
+				continue
+			}
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	return events, nil
+}
+
+// eventTime returns the time the event was recorded last time
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.FirstTimestamp.Time
+	}
+}
+
+// setKind returns the kind of the networkset
+func setKind(obj client.Object) string {
+	if _, ok := obj.(*calicov3.GlobalNetworkSet); ok {
+		return calicov3.KindGlobalNetworkSet
+	}
+	return calicov3.KindNetworkSet
+}
+
+// formatTime formats the time with its age, "<none>" for the zero time
+func formatTime(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "<none>"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.RFC3339), age(t, now))
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/controller"
+)
+
+// list prints the policies with their domains, networksets and the number of addresses
+func list(args []string) error {
+	o := &options{}
+	flags := newFlagSet("list", "",
+		"List the policies, the domains of their selectors, the networksets the domains are resolved to and the number of addresses.", o)
+	if _, err := o.parse(flags, args); err != nil {
+		return err
+	}
+
+	sets, err := o.listSets(context.Background())
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(sets, func(i, j int) bool {
+		if sets[i].Object.GetNamespace() != sets[j].Object.GetNamespace() {
+			return sets[i].Object.GetNamespace() < sets[j].Object.GetNamespace()
+		}
+		if sets[i].Policy != sets[j].Policy {
+			return sets[i].Policy < sets[j].Policy
+		}
+		return sets[i].Domain < sets[j].Domain
+	})
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOLICY\tLABEL\tDOMAIN\tSET\tADDRESSES\tLAST RESOLVE\tSTATUS")
+	for _, set := range sets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", namespaceOf(set.Object), policyReference(set), set.Label, set.Domain,
+			setReference(set.Object), len(set.Nets), age(set.LastResolve, now), setStatus(set))
+	}
+	return w.Flush()
+}
+
+// setStatus summarizes the annotations of the networkset
+func setStatus(set controller.ManagedSet) string {
+	switch {
+	case set.ManualOverride:
+		return "ManualOverride"
+	case set.RefreshRequested:
+		return "RefreshRequested"
+	case !set.FailedSince.IsZero():
+		return "ResolveFailed"
+	default:
+		return "Resolved"
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command kubectl-networksets is the kubectl plugin inspecting the networksets managed by the controller
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/config"
+	"github.com/javdet/networksets-controller/internal/controller"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Usage: kubectl networksets <command> [flags]
+
+Commands:
+  list                  List the policies, their domains, networksets and the number of addresses
+  describe SET          Show the addresses and the resolve history of the networkset
+  refresh SET|DOMAIN    Resolve the networkset or all networksets of the domain immediately
+  orphans               List the managed networksets whose parent policy is gone
+
+SET is NAME, networkset/NAME or globalnetworkset/NAME.
+Run "kubectl networksets <command> -h" for the flags of the command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command := os.Args[1]; command {
+	case "list":
+		err = list(os.Args[2:])
+	case "describe":
+		err = describe(os.Args[2:])
+	case "refresh":
+		err = refresh(os.Args[2:])
+	case "orphans":
+		err = orphans(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// options are the flags of the cluster connection shared by all commands
+type options struct {
+	kubeconfig    string
+	context       string
+	namespace     string
+	allNamespaces bool
+	configFile    string
+
+	client client.Client
+}
+
+func newFlagSet(name string, arguments string, description string, o *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kubectl networksets %s [flags] %s\n\n%s\n\nFlags:\n", name, arguments, description)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, the default loading rules of kubectl are used if not set.")
+	flags.StringVar(&o.context, "context", "", "The name of the kubeconfig context to use.")
+	flags.StringVar(&o.namespace, "namespace", "", "The namespace of the networksets, the namespace of the kubeconfig context if not set.")
+	flags.StringVar(&o.namespace, "n", "", "Shorthand for --namespace.")
+	flags.BoolVar(&o.allNamespaces, "all-namespaces", false, "If set, the networksets of all namespaces are used.")
+	flags.BoolVar(&o.allNamespaces, "A", false, "Shorthand for --all-namespaces.")
+	flags.StringVar(&o.configFile, "config", "",
+		"The configuration file of the controller manager, its label keys, annotation prefix and DNS label are used. "+
+			"The default keys are used if not set.")
+	return flags
+}
+
+// parse parses the flags placed before and after the arguments like kubectl does and connects to the cluster
+func (o *options) parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var arguments []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		arguments = append(arguments, args[0])
+		args = args[1:]
+	}
+
+	if o.configFile != "" {
+		cfg, err := config.Load(o.configFile)
+		if err != nil {
+			return nil, err
+		}
+		controller.SetKeys(cfg.ControllerKeys())
+		controller.SetDNSLabel(cfg.Resolvers.DNS.Label)
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	overrides.Context.Namespace = o.namespace
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.namespace, _, err = clientConfig.Namespace(); err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := calicov3.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	o.client, err = client.New(restConfig, client.Options{Scheme: scheme})
+	return arguments, err
+}
+
+// listSets returns the managed networksets of the namespace or of all namespaces and the managed globalnetworksets
+func (o *options) listSets(ctx context.Context) ([]controller.ManagedSet, error) {
+	listOptions := []client.ListOption{controller.ManagedLabels()}
+	if !o.allNamespaces {
+		listOptions = append(listOptions, client.InNamespace(o.namespace))
+	}
+	networkSetList := &calicov3.NetworkSetList{}
+	if err := o.client.List(ctx, networkSetList, listOptions...); err != nil {
+		return nil, err
+	}
+	globalNetworkSetList := &calicov3.GlobalNetworkSetList{}
+	if err := o.client.List(ctx, globalNetworkSetList, controller.ManagedLabels()); err != nil {
+		return nil, err
+	}
+
+	var sets []controller.ManagedSet
+	for i := range networkSetList.Items {
+		if set, ok := controller.InspectSet(&networkSetList.Items[i]); ok {
+			sets = append(sets, set)
+		}
+	}
+	for i := range globalNetworkSetList.Items {
+		if set, ok := controller.InspectSet(&globalNetworkSetList.Items[i]); ok {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// getSet returns the managed networkset by NAME, networkset/NAME or globalnetworkset/NAME,
+// the networkset of the namespace is looked up before the globalnetworkset with the name
+func (o *options) getSet(ctx context.Context, name string) (controller.ManagedSet, error) {
+	var candidates []client.Object
+	kind, setName, ok := strings.Cut(name, "/")
+	if !ok {
+		kind, setName = "", name
+	}
+	switch strings.ToLower(kind) {
+	case "networkset", "networksets", "netset":
+		candidates = []client.Object{&calicov3.NetworkSet{}}
+	case "globalnetworkset", "globalnetworksets", "gnetset":
+		candidates = []client.Object{&calicov3.GlobalNetworkSet{}}
+	default:
+		if ok {
+			return controller.ManagedSet{}, fmt.Errorf("unknown kind %q, expected networkset or globalnetworkset", kind)
+		}
+		candidates = []client.Object{&calicov3.NetworkSet{}, &calicov3.GlobalNetworkSet{}}
+	}
+
+	for _, obj := range candidates {
+		key := types.NamespacedName{Name: setName}
+		if _, namespaced := obj.(*calicov3.NetworkSet); namespaced {
+			key.Namespace = o.namespace
+		}
+		err := o.client.Get(ctx, key, obj)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return controller.ManagedSet{}, err
+		}
+		set, managed := controller.InspectSet(obj)
+		if !managed {
+			return controller.ManagedSet{}, fmt.Errorf("%s is not managed by the controller", setReference(obj))
+		}
+		return set, nil
+	}
+	return controller.ManagedSet{}, fmt.Errorf("%s: %w", name, errSetNotFound)
+}
+
+var errSetNotFound = errors.New("networkset not found")
+
+// setReference returns kind/name of the networkset
+func setReference(obj client.Object) string {
+	return strings.ToLower(setKind(obj)) + "/" + obj.GetName()
+}
+
+// policyReference returns kind/name of the parent policy of the networkset
+func policyReference(set controller.ManagedSet) string {
+	if _, ok := set.Object.(*calicov3.GlobalNetworkSet); ok {
+		return "globalnetworkpolicy/" + set.Policy
+	}
+	return "networkpolicy/" + set.Policy
+}
+
+// namespaceOf returns the namespace of the object, "-" for the cluster scoped objects
+func namespaceOf(obj client.Object) string {
+	if obj.GetNamespace() == "" {
+		return "-"
+	}
+	return obj.GetNamespace()
+}
+
+// age formats the time passed since the time like kubectl does, "<unknown>" for the zero time
+func age(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(now.Sub(t))
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/controller"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// orphans prints the managed networksets whose parent policy no longer exists or was re-created with another uid.
+// The controller removes them at startup
+func orphans(args []string) error {
+	o := &options{}
+	flags := newFlagSet("orphans", "",
+		"List the managed networksets whose parent policy no longer exists or was re-created with another uid. "+
+			"The controller removes them when it is restarted.", o)
+	if _, err := o.parse(flags, args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	sets, err := o.listSets(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tSET\tPOLICY\tREASON\tAGE")
+	for _, set := range sets {
+		reason, err := o.orphanReason(ctx, set)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", namespaceOf(set.Object), setReference(set.Object), policyReference(set), reason,
+			age(set.Object.GetCreationTimestamp().Time, now))
+	}
+	return w.Flush()
+}
+
+// orphanReason returns why the networkset is orphaned, empty if its parent policy exists
+func (o *options) orphanReason(ctx context.Context, set controller.ManagedSet) (string, error) {
+	// the networksets labeled manually without the parent policy are not removed by the controller
+	if set.Policy == "" {
+		return "", nil
+	}
+	var policy client.Object = &calicov3.NetworkPolicy{}
+	if _, ok := set.Object.(*calicov3.GlobalNetworkSet); ok {
+		policy = &calicov3.GlobalNetworkPolicy{}
+	}
+	err := o.client.Get(ctx, types.NamespacedName{Namespace: set.Object.GetNamespace(), Name: set.Policy}, policy)
+	if apierrors.IsNotFound(err) {
+		return "PolicyNotFound", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if controller.IsOrphan(set, policy) {
+		return "PolicyRecreated", nil
+	}
+	return "", nil
+}
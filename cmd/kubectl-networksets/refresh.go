@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/controller"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// refresh annotates the networkset or all networksets of the domain to be resolved immediately
+func refresh(args []string) error {
+	o := &options{}
+	flags := newFlagSet("refresh", "SET|DOMAIN",
+		"Resolve the networkset or all networksets of the domain immediately, bypassing the cache of the controller. "+
+			"The networksets of the domain are looked up in the namespace, or in all namespaces with --all-namespaces, "+
+			"and in the globalnetworksets.", o)
+	var timeout time.Duration
+	flags.DurationVar(&timeout, "wait", 0, "If set, wait for the controller to resolve the networksets up to the duration.")
+	arguments, err := o.parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(arguments) != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one networkset or domain, got %d arguments", len(arguments))
+	}
+
+	ctx := context.Background()
+	sets, err := o.refreshedSets(ctx, arguments[0])
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var requested []controller.ManagedSet
+	for _, set := range sets {
+		if set.ManualOverride {
+			fmt.Fprintf(os.Stderr, "Warning: %s has the manual override, it is not resolved\n", setReference(set.Object))
+			continue
+		}
+		patch := client.MergeFrom(set.Object.DeepCopyObject().(client.Object))
+		controller.RequestRefresh(set.Object, now)
+		if err := o.client.Patch(ctx, set.Object, patch); err != nil {
+			return fmt.Errorf("%s: %w", setReference(set.Object), err)
+		}
+		fmt.Printf("%s refresh requested\n", setReference(set.Object))
+		requested = append(requested, set)
+	}
+	if timeout == 0 {
+		return nil
+	}
+
+	for _, set := range requested {
+		err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+			if err := o.client.Get(ctx, client.ObjectKeyFromObject(set.Object), set.Object); err != nil {
+				return false, err
+			}
+			set, _ = controller.InspectSet(set.Object)
+			return !set.RefreshRequested, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s is not resolved: %w", setReference(set.Object), err)
+		}
+		if !set.FailedSince.IsZero() {
+			fmt.Printf("%s resolve failed: %s\n", setReference(set.Object), set.LastError)
+			continue
+		}
+		fmt.Printf("%s resolved, %d addresses\n", setReference(set.Object), len(set.Nets))
+	}
+	return nil
+}
+
+// refreshedSets returns the networkset by its name or the networksets of the domain when there is no networkset with the name
+func (o *options) refreshedSets(ctx context.Context, name string) ([]controller.ManagedSet, error) {
+	set, err := o.getSet(ctx, name)
+	if err == nil {
+		return []controller.ManagedSet{set}, nil
+	}
+	if !errors.Is(err, errSetNotFound) || strings.Contains(name, "/") {
+		return nil, err
+	}
+
+	sets, err := o.listSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var domainSets []controller.ManagedSet
+	for _, set := range sets {
+		if set.Domain == name {
+			domainSets = append(domainSets, set)
+		}
+	}
+	if len(domainSets) == 0 {
+		return nil, fmt.Errorf("no networkset or domain %q", name)
+	}
+	return domainSets, nil
+}
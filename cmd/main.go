@@ -19,17 +19,24 @@ package main
 import (
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
-	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
-	// to ensure that exec-entrypoint and run can make use of them.
+	networksetsv1alpha1 "github.com/javdet/networksets-controller/api/v1alpha1"
+	"github.com/javdet/networksets-controller/internal/config"
 	"github.com/javdet/networksets-controller/internal/controller"
+	"github.com/javdet/networksets-controller/internal/resolver"
 	"github.com/javdet/networksets-controller/monitoring"
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -48,16 +55,67 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(monitoringv1.AddToScheme(scheme))
 	utilruntime.Must(calicov3.AddToScheme(scheme))
+	utilruntime.Must(networksetsv1alpha1.AddToScheme(scheme))
 	monitoring.RegisterMetrics()
 	//+kubebuilder:scaffold:scheme
 }
 
+// resolverFlag collects resolvers from LABEL=ARG flag values
+type resolverFlag struct {
+	values []string
+}
+
+func (f *resolverFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.values, ",")
+}
+
+func (f *resolverFlag) Set(value string) error {
+	label, arg, ok := strings.Cut(value, "=")
+	if !ok || label == "" || arg == "" {
+		return fmt.Errorf("expected LABEL=VALUE, got %q", value)
+	}
+	f.values = append(f.values, value)
+	return nil
+}
+
+// resolvers returns label and argument of the resolvers
+func (f *resolverFlag) resolvers() [][2]string {
+	var resolvers [][2]string
+	for _, value := range f.values {
+		label, arg, _ := strings.Cut(value, "=")
+		resolvers = append(resolvers, [2]string{label, arg})
+	}
+	return resolvers
+}
+
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var enableWebhook bool
+	var webhookResolveTimeout time.Duration
+	var webhookPrepopulate bool
+	var dnsLabel string
+	var dnsServers string
+	var dnsResolvConf string
+	var resolveCache bool
+	var failureActionName string
+	var failureGracePeriod time.Duration
+	var refreshInterval time.Duration
+	var minRefreshInterval time.Duration
+	var maxRefreshInterval time.Duration
+	var addressFamilyName string
+	var metricsDomainAllowlist string
+	var metricsMaxDomains int
+	var metricsDomainExpiry time.Duration
+	var configFile string
+	var dryRun bool
+	var httpResolvers, fileResolvers resolverFlag
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -67,6 +125,56 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false,
+		"If set, the validating webhook of NetworkPolicy and GlobalNetworkPolicy resolver selectors is enabled.")
+	flag.DurationVar(&webhookResolveTimeout, "webhook-resolve-timeout", 2*time.Second,
+		"The timeout of resolving all domains of the policy by the validating webhook, the domains are not resolved for the warnings if zero.")
+	flag.BoolVar(&webhookPrepopulate, "webhook-prepopulate", true,
+		"If set, the validating webhook creates networksets of the new policy before the policy is admitted.")
+	flag.Var(&httpResolvers, "http-resolver",
+		"Selector label resolved by the http url in LABEL=URL format, {value} in the url is replaced by the label value. "+
+			"Can be specified multiple times.")
+	flag.Var(&fileResolvers, "file-resolver",
+		"Selector label resolved by the file named by the label value in LABEL=DIR format. "+
+			"Can be specified multiple times.")
+	flag.StringVar(&dnsLabel, "dns-label", resolver.DNSKey,
+		"Selector label resolved by DNS, the domains of the domainsets are resolved by this label.")
+	flag.StringVar(&dnsServers, "dns-server", "",
+		"Comma separated list of upstream DNS servers in host[:port] format. "+
+			"If not set the nameservers from --dns-resolv-conf are used.")
+	flag.StringVar(&dnsResolvConf, "dns-resolv-conf", resolver.DefaultResolvConf,
+		"The resolv.conf file with upstream DNS servers.")
+	flag.BoolVar(&resolveCache, "resolve-cache", true,
+		"If set, the value used by many networksets is resolved once per refresh interval and the result is shared.")
+	flag.StringVar(&failureActionName, "resolve-failure-action", string(controller.FailureKeep),
+		"The action when the domain is not resolved longer than the grace period: "+
+			"Keep the last known good networks or Empty the networkset.")
+	flag.DurationVar(&failureGracePeriod, "resolve-failure-grace-period", 10*time.Minute,
+		"The time the last known good networks are kept when the domain is not resolved.")
+	flag.DurationVar(&refreshInterval, "refresh-interval", 5*time.Second,
+		"The interval of networkset refresh for the resolvers without TTL, bounded by the minimal and maximal intervals.")
+	flag.DurationVar(&minRefreshInterval, "min-refresh-interval", 5*time.Second,
+		"The minimal interval of networkset refresh, the shorter DNS TTL is raised to this value.")
+	flag.DurationVar(&maxRefreshInterval, "max-refresh-interval", 5*time.Minute,
+		"The maximal interval of networkset refresh, the longer DNS TTL is lowered to this value.")
+	flag.StringVar(&addressFamilyName, "address-family", string(resolver.DualStack),
+		"Default address family of networksets: IPv4, IPv6 or Dual. "+
+			"Can be overridden by networksets.javdet.io/address-family annotation of the policy.")
+	flag.StringVar(&metricsDomainAllowlist, "metrics-domain-allowlist", "",
+		"Comma separated list of the domain patterns (for example *.example.com) exported in the per-domain metrics, all domains if empty. "+
+			"Applied on restart, it is not reloaded from the config file.")
+	flag.IntVar(&metricsMaxDomains, "metrics-max-domains", 100,
+		"Maximal number of distinct domains exported in the per-domain metrics at the same time, other domains are reported as _other. "+
+			"0 is no limit. Applied on restart, it is not reloaded from the config file.")
+	flag.DurationVar(&metricsDomainExpiry, "metrics-domain-expiry", 30*time.Minute,
+		"The domain which was not resolved for this time is replaced by the new domain in the per-domain metrics when "+
+			"metrics-max-domains is reached, its series are deleted. Should be longer than max-refresh-interval, 0 keeps the first domains.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"If set, the controller computes the changes of the managed resources without applying them. "+
+			"The changes are logged with the diff and counted in the dry-run metrics.")
+	flag.StringVar(&configFile, "config", "",
+		"The configuration file of the controller manager. If set, the refresh, resolve failure, address family "+
+			"and resolver flags are ignored and the file is reloaded when it changes.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -75,6 +183,50 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	var cfg *config.ControllerConfig
+	var err error
+	if configFile != "" {
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load configuration", "path", configFile)
+			os.Exit(1)
+		}
+	} else {
+		cfg = config.Default()
+		cfg.Refresh.Interval = metav1.Duration{Duration: refreshInterval}
+		cfg.Refresh.MinInterval = metav1.Duration{Duration: minRefreshInterval}
+		cfg.Refresh.MaxInterval = metav1.Duration{Duration: maxRefreshInterval}
+		cfg.Refresh.Cache = &resolveCache
+		cfg.ResolveFailure.Action = failureActionName
+		cfg.ResolveFailure.GracePeriod = &metav1.Duration{Duration: failureGracePeriod}
+		cfg.AddressFamily = addressFamilyName
+		cfg.Resolvers.DNS.Label = dnsLabel
+		if dnsServers != "" {
+			cfg.Resolvers.DNS.Servers = config.SplitList(dnsServers)
+		}
+		cfg.Resolvers.DNS.ResolvConf = dnsResolvConf
+		for _, httpResolver := range httpResolvers.resolvers() {
+			cfg.Resolvers.HTTP = append(cfg.Resolvers.HTTP, config.HTTPResolverConfig{Label: httpResolver[0], URL: httpResolver[1]})
+		}
+		for _, fileResolver := range fileResolvers.resolvers() {
+			cfg.Resolvers.File = append(cfg.Resolvers.File, config.FileResolverConfig{Label: fileResolver[0], Dir: fileResolver[1]})
+		}
+		if err = config.Validate(cfg); err != nil {
+			setupLog.Error(err, "invalid flags")
+			os.Exit(1)
+		}
+	}
+
+	controller.SetKeys(cfg.ControllerKeys())
+	settings := controller.NewSettingsStore(cfg.ReconcilerSettings())
+	resolvers := resolver.NewRegistry()
+	if err = config.ApplyResolvers(resolvers, cfg, nil); err != nil {
+		setupLog.Error(err, "unable to set up resolvers")
+		os.Exit(1)
+	}
+
+	monitoring.UseDomainFilter(monitoring.NewDomainFilter(config.SplitList(metricsDomainAllowlist), metricsMaxDomains, metricsDomainExpiry))
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancelation and
@@ -123,39 +275,124 @@ func main() {
 		os.Exit(1)
 	}
 
+	// in the dry-run mode the resources and the events are not written
+	managerClient := mgr.GetClient()
+	recorder := mgr.GetEventRecorderFor("networksets-controller")
+	if dryRun {
+		setupLog.Info("dry-run mode, the changes are not applied")
+		managerClient = controller.NewDryRunClient(managerClient)
+		recorder = controller.NewDryRunRecorder()
+		monitoring.SetDryRun(true)
+	}
+
 	if err = (&controller.NetworkPolicyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  managerClient,
+		Scheme:                  mgr.GetScheme(),
+		Resolvers:               resolvers,
+		Settings:                settings,
+		MaxConcurrentReconciles: cfg.Concurrency.Policies,
+		Recorder:                recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Networkpolicy")
 		os.Exit(1)
 	}
 
 	if err = (&controller.GlobalNetworkPolicyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  managerClient,
+		Scheme:                  mgr.GetScheme(),
+		Resolvers:               resolvers,
+		Settings:                settings,
+		MaxConcurrentReconciles: cfg.Concurrency.Policies,
+		Recorder:                recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "GlobalNetworkpolicy")
 		os.Exit(1)
 	}
 
 	if err = (&controller.NetworkSetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  managerClient,
+		Scheme:                  mgr.GetScheme(),
+		Resolvers:               resolvers,
+		Settings:                settings,
+		MaxConcurrentReconciles: cfg.Concurrency.NetworkSets,
+		Recorder:                recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Networkset")
 		os.Exit(1)
 	}
 
 	if err = (&controller.GlobalNetworkSetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  managerClient,
+		Scheme:                  mgr.GetScheme(),
+		Resolvers:               resolvers,
+		Settings:                settings,
+		MaxConcurrentReconciles: cfg.Concurrency.NetworkSets,
+		Recorder:                recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "GlobalNetworkset")
 		os.Exit(1)
 	}
+
+	if err = (&controller.DomainSetReconciler{
+		Client:                  managerClient,
+		Scheme:                  mgr.GetScheme(),
+		Resolvers:               resolvers,
+		Settings:                settings,
+		MaxConcurrentReconciles: cfg.Concurrency.DomainSets,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DomainSet")
+		os.Exit(1)
+	}
+	if err = (&controller.GlobalDomainSetReconciler{
+		Client:                  managerClient,
+		Scheme:                  mgr.GetScheme(),
+		Resolvers:               resolvers,
+		Settings:                settings,
+		MaxConcurrentReconciles: cfg.Concurrency.DomainSets,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "GlobalDomainSet")
+		os.Exit(1)
+	}
+
+	if enableWebhook {
+		if err = (&controller.PolicyValidator{
+			Client:         managerClient,
+			Resolvers:      resolvers,
+			Settings:       settings,
+			ResolveTimeout: webhookResolveTimeout,
+			Prepopulate:    webhookPrepopulate,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Policy")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if err = mgr.Add(&controller.OrphanCleaner{
+		Client: managerClient,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up orphaned networksets cleaner")
+		os.Exit(1)
+	}
+
+	if configFile != "" {
+		applied := cfg
+		if err = mgr.Add(config.NewWatcher(configFile, cfg, func(cfg *config.ControllerConfig) error {
+			if err := config.ApplyResolvers(resolvers, cfg, applied); err != nil {
+				return fmt.Errorf("unable to apply resolvers of the reloaded configuration: %w", err)
+			}
+			settings.Store(cfg.ReconcilerSettings())
+			if cfg.Keys != applied.Keys || cfg.Concurrency != applied.Concurrency {
+				setupLog.Info("keys and concurrency of the reloaded configuration are applied on restart")
+			}
+			applied = cfg
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to set up configuration watcher")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command networksets runs the controller logic offline
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: networksets <command> [flags]
+
+Commands:
+  render    Render NetworkSets and GlobalNetworkSets of the Calico policies read from the files or stdin
+
+Run "networksets <command> -h" for the flags of the command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command := os.Args[1]; command {
+	case "render":
+		err = render(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// resolverFlag collects resolvers from LABEL=ARG flag values
+type resolverFlag struct {
+	values [][2]string
+}
+
+func (f *resolverFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var values []string
+	for _, value := range f.values {
+		values = append(values, value[0]+"="+value[1])
+	}
+	return strings.Join(values, ",")
+}
+
+func (f *resolverFlag) Set(value string) error {
+	label, arg, ok := strings.Cut(value, "=")
+	if !ok || label == "" || arg == "" {
+		return fmt.Errorf("expected LABEL=VALUE, got %q", value)
+	}
+	f.values = append(f.values, [2]string{label, arg})
+	return nil
+}
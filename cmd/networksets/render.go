@@ -0,0 +1,261 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/internal/config"
+	"github.com/javdet/networksets-controller/internal/controller"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// render writes the networksets of the policies read from the files or stdin to stdout or to the directory
+func render(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: networksets render [flags] [FILE|-]...\n\n"+
+			"Render NetworkSets and GlobalNetworkSets of the Calico NetworkPolicies and GlobalNetworkPolicies\n"+
+			"like the controller creates them. The policies are read from the files or stdin if no file is given.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	var configFile, dnsServers, addressFamily, namespace, outputDir string
+	var status, failOnError, verbose bool
+	var timeout time.Duration
+	var httpResolvers, fileResolvers resolverFlag
+	flags.StringVar(&configFile, "config", "",
+		"The configuration file of the controller manager with the resolvers, the keys and the defaults of the networksets. "+
+			"If set, the resolver and address family flags are ignored.")
+	flags.StringVar(&dnsServers, "dns-server", "",
+		"Comma separated list of upstream DNS servers in host[:port] format. "+
+			"If not set the nameservers from "+resolver.DefaultResolvConf+" are used.")
+	flags.Var(&httpResolvers, "http-resolver",
+		"Selector label resolved by the http url in LABEL=URL format. Can be specified multiple times.")
+	flags.Var(&fileResolvers, "file-resolver",
+		"Selector label resolved by the file named by the label value in LABEL=DIR format. Can be specified multiple times.")
+	flags.StringVar(&addressFamily, "address-family", string(resolver.DualStack),
+		"Default address family of networksets: IPv4, IPv6 or Dual.")
+	flags.StringVar(&namespace, "namespace", "default", "The namespace of the NetworkPolicies without namespace.")
+	flags.StringVar(&outputDir, "output-dir", "",
+		"The directory the networksets are written to, one file per networkset. If not set the networksets are written to stdout.")
+	flags.BoolVar(&status, "status", false,
+		"If set, the source, the time and the result of the resolve are written to the annotations like the controller does. "+
+			"The output is changed on every render then.")
+	flags.BoolVar(&failOnError, "fail-on-error", false,
+		"If set, nothing is written when a domain is not resolved. Otherwise the networkset is rendered with the failure annotations.")
+	flags.DurationVar(&timeout, "timeout", time.Minute, "The timeout of resolving all domains.")
+	flags.BoolVar(&verbose, "v", false, "If set, the resolver logs are written to stderr.")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	logger := logr.Discard()
+	if verbose {
+		logger = zap.New(zap.WriteTo(os.Stderr))
+	}
+	ctrl.SetLogger(logger)
+
+	cfg, err := renderConfig(configFile, dnsServers, addressFamily, httpResolvers, fileResolvers)
+	if err != nil {
+		return err
+	}
+	controller.SetKeys(cfg.ControllerKeys())
+	resolvers := resolver.NewRegistry()
+	if err := config.ApplyResolvers(resolvers, cfg, nil); err != nil {
+		return err
+	}
+
+	policies, err := readPolicies(flags.Args(), namespace)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	now := time.Now()
+	var networkSets []client.Object
+	var resolveErrs []error
+	for _, policy := range policies {
+		rendered, err := controller.RenderNetworkSets(ctx, resolvers, cfg.ReconcilerSettings(), policy, now)
+		if err != nil {
+			resolveErrs = append(resolveErrs, err)
+		}
+		networkSets = append(networkSets, rendered...)
+	}
+	if len(resolveErrs) > 0 {
+		if failOnError {
+			return errors.Join(resolveErrs...)
+		}
+		fmt.Fprintln(os.Stderr, "Warning:", errors.Join(resolveErrs...))
+	}
+	if !status {
+		for _, networkSet := range networkSets {
+			controller.RemoveResolveStatus(networkSet)
+		}
+	}
+
+	if outputDir == "" {
+		return writeManifests(os.Stdout, networkSets)
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+	for _, networkSet := range networkSets {
+		var buf bytes.Buffer
+		if err := writeManifests(&buf, []client.Object{networkSet}); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(outputDir, manifestName(networkSet)), buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderConfig loads the configuration file or builds the configuration from the flags
+func renderConfig(configFile string, dnsServers string, addressFamily string, httpResolvers resolverFlag, fileResolvers resolverFlag) (*config.ControllerConfig, error) {
+	if configFile != "" {
+		return config.Load(configFile)
+	}
+	cfg := config.Default()
+	cfg.AddressFamily = addressFamily
+	if dnsServers != "" {
+		cfg.Resolvers.DNS.Servers = config.SplitList(dnsServers)
+	}
+	for _, httpResolver := range httpResolvers.values {
+		cfg.Resolvers.HTTP = append(cfg.Resolvers.HTTP, config.HTTPResolverConfig{Label: httpResolver[0], URL: httpResolver[1]})
+	}
+	for _, fileResolver := range fileResolvers.values {
+		cfg.Resolvers.File = append(cfg.Resolvers.File, config.FileResolverConfig{Label: fileResolver[0], Dir: fileResolver[1]})
+	}
+	if err := config.Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid flags: %w", err)
+	}
+	return cfg, nil
+}
+
+// readPolicies reads NetworkPolicies and GlobalNetworkPolicies from the YAML or JSON files, "-" or no files is stdin.
+// Other documents are skipped with the warning
+func readPolicies(files []string, namespace string) ([]client.Object, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var policies []client.Object
+	for _, file := range files {
+		filePolicies, err := readPolicyFile(file, namespace)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, filePolicies...)
+	}
+	return policies, nil
+}
+
+// readPolicyFile reads the policies of one file, the file is closed before the next file is read
+func readPolicyFile(file string, namespace string) ([]client.Object, error) {
+	var reader io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		reader = f
+	}
+	var policies []client.Object
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		document := &unstructured.Unstructured{}
+		err := decoder.Decode(&document.Object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if len(document.Object) == 0 {
+			continue
+		}
+		var policy client.Object
+		switch gvk := document.GroupVersionKind(); {
+		case gvk == calicov3.SchemeGroupVersion.WithKind(calicov3.KindNetworkPolicy):
+			policy = &calicov3.NetworkPolicy{}
+		case gvk == calicov3.SchemeGroupVersion.WithKind(calicov3.KindGlobalNetworkPolicy):
+			policy = &calicov3.GlobalNetworkPolicy{}
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: %s: skip %s %s\n", file, document.GetKind(), document.GetName())
+			continue
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(document.Object, policy); err != nil {
+			return nil, fmt.Errorf("%s: %s %s: %w", file, document.GetKind(), document.GetName(), err)
+		}
+		if _, ok := policy.(*calicov3.NetworkPolicy); ok && policy.GetNamespace() == "" {
+			policy.SetNamespace(namespace)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// writeManifests writes the objects as YAML documents, the empty creation timestamp is omitted
+func writeManifests(w io.Writer, objects []client.Object) error {
+	for _, obj := range objects {
+		manifest, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		unstructured.RemoveNestedField(manifest, "metadata", "creationTimestamp")
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifestName returns the file name of the networkset in the output directory
+func manifestName(obj client.Object) string {
+	kind := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind)
+	if obj.GetNamespace() == "" {
+		return fmt.Sprintf("%s-%s.yaml", kind, obj.GetName())
+	}
+	return fmt.Sprintf("%s-%s-%s.yaml", kind, obj.GetNamespace(), obj.GetName())
+}
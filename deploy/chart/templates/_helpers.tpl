@@ -37,6 +37,13 @@ Define the name of the secret containing the tokens
 {{- default (include "networkset-controller.fullname" .) .Values.runners.secret | quote -}}
 {{- end -}}
 
+{{/*
+Define the secret with the webhook server certificate
+*/}}
+{{- define "networkset-controller.webhook-secret" -}}
+{{- default (printf "%s-webhook-cert" (include "networkset-controller.fullname" .)) .Values.webhook.certSecret -}}
+{{- end -}}
+
 {{/*
 Define the image, using .Chart.AppVersion and networkset controller image as a default value
 */}}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/javdet/networksets-controller/internal/controller"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// ControllerKeys returns the label keys and the annotation prefix of the managed networksets
+func (cfg *ControllerConfig) ControllerKeys() controller.Keys {
+	return controller.Keys{
+		ControlPlaneLabel:    cfg.Keys.ControlPlaneLabel,
+		ControlPlaneValue:    cfg.Keys.ControlPlaneValue,
+		ParentPolicyLabel:    cfg.Keys.ParentPolicyLabel,
+		ParentPolicyUIDLabel: cfg.Keys.ParentPolicyUIDLabel,
+		AnnotationPrefix:     cfg.Keys.AnnotationPrefix,
+	}
+}
+
+// ReconcilerSettings returns the settings of the reconcilers from the validated configuration
+func (cfg *ControllerConfig) ReconcilerSettings() controller.Settings {
+	addressFamily, _ := resolver.ParseAddressFamily(cfg.AddressFamily)
+	failureAction, _ := controller.ParseFailureAction(cfg.ResolveFailure.Action)
+	return controller.Settings{
+		RefreshInterval:    cfg.Refresh.Interval.Duration,
+		MinRefreshInterval: cfg.Refresh.MinInterval.Duration,
+		MaxRefreshInterval: cfg.Refresh.MaxInterval.Duration,
+		AddressFamily:      addressFamily,
+		FailurePolicy: controller.FailurePolicy{
+			Action:      failureAction,
+			GracePeriod: cfg.ResolveFailure.GracePeriod.Duration,
+		},
+		Namespaces: controller.NamespaceFilter{
+			Include: cfg.Namespaces.Include,
+			Exclude: cfg.Namespaces.Exclude,
+		},
+		RequireAllowedDomains: cfg.Namespaces.RequireAllowedDomains,
+	}
+}
+
+// ApplyResolvers registers the resolvers of the configuration and removes the resolvers of the previous configuration,
+// the http and file resolvers replace the DNS resolver of the same label.
+// The DNS resolver is registered by the configured label, the resolver of the previous label is removed.
+// The cache is replaced only when the refresh configuration is changed, so the cached results are kept on reload
+func ApplyResolvers(resolvers *resolver.Registry, cfg *ControllerConfig, previous *ControllerConfig) error {
+	servers := cfg.Resolvers.DNS.Servers
+	if len(servers) == 0 {
+		var err error
+		servers, err = resolver.ReadResolvConf(cfg.Resolvers.DNS.ResolvConf)
+		if err != nil {
+			return fmt.Errorf("unable to read upstream DNS servers: %w", err)
+		}
+	}
+
+	dnsLabel := cfg.Resolvers.DNS.Label
+	labels := map[string]bool{dnsLabel: true}
+	configLog.Info("using upstream DNS servers", "label", dnsLabel, "servers", servers)
+	resolvers.SetDNSKey(dnsLabel)
+	resolvers.Register(dnsLabel, resolver.NewDNSResolver(servers))
+	for _, httpResolver := range cfg.Resolvers.HTTP {
+		labels[httpResolver.Label] = true
+		resolvers.Register(httpResolver.Label, resolver.NewHTTPResolver(httpResolver.URL))
+	}
+	for _, fileResolver := range cfg.Resolvers.File {
+		labels[fileResolver.Label] = true
+		resolvers.Register(fileResolver.Label, resolver.NewFileResolver(fileResolver.Dir))
+	}
+	for _, label := range resolvers.Keys() {
+		if !labels[label] {
+			configLog.Info("remove resolver", "label", label)
+			resolvers.Unregister(label)
+		}
+	}
+
+	if previous == nil || !equality.Semantic.DeepEqual(cfg.Refresh, previous.Refresh) {
+		if *cfg.Refresh.Cache {
+			resolvers.UseCache(resolver.NewCache(cfg.Refresh.MinInterval.Duration, cfg.Refresh.Interval.Duration))
+		} else {
+			resolvers.UseCache(nil)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+)
+
+func TestApplyResolversDNSLabel(t *testing.T) {
+	resolvers := resolver.NewRegistry()
+	previous := Default()
+	previous.Resolvers.DNS.Servers = []string{"127.0.0.1:53"}
+	if err := ApplyResolvers(resolvers, previous, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Default()
+	cfg.Resolvers.DNS.Label = "DNS"
+	cfg.Resolvers.DNS.Servers = []string{"127.0.0.1:53"}
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("configuration with the DNS label is invalid: %v", err)
+	}
+	if err := ApplyResolvers(resolvers, cfg, previous); err != nil {
+		t.Fatal(err)
+	}
+	if keys := resolvers.Keys(); !reflect.DeepEqual(keys, []string{"DNS"}) {
+		t.Errorf("registered labels are %v, expected [DNS]", keys)
+	}
+	if key := resolvers.DNSKey(); key != "DNS" {
+		t.Errorf("DNS label of the registry is %s, expected DNS", key)
+	}
+}
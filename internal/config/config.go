@@ -0,0 +1,356 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the versioned configuration file of the controller manager
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/controller"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the version of the configuration file
+	APIVersion = "networksets.javdet.io/v1alpha1"
+	// Kind is the kind of the configuration file
+	Kind = "ControllerConfig"
+)
+
+// ControllerConfig is the configuration file of the controller manager.
+// Refresh, ResolveFailure, AddressFamily, Resolvers and Namespaces are applied on reload,
+// Keys and Concurrency are applied on restart
+type ControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Refresh        RefreshConfig        `json:"refresh,omitempty"`
+	ResolveFailure ResolveFailureConfig `json:"resolveFailure,omitempty"`
+	// AddressFamily is the default address family of networksets: IPv4, IPv6 or Dual
+	AddressFamily string            `json:"addressFamily,omitempty"`
+	Resolvers     ResolversConfig   `json:"resolvers,omitempty"`
+	Keys          KeysConfig        `json:"keys,omitempty"`
+	Concurrency   ConcurrencyConfig `json:"concurrency,omitempty"`
+	Namespaces    NamespacesConfig  `json:"namespaces,omitempty"`
+}
+
+// RefreshConfig defines how often the networksets are resolved
+type RefreshConfig struct {
+	// Interval is used for the resolvers without TTL, bounded by the minimal and maximal intervals
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// MinInterval raises the shorter DNS TTL
+	MinInterval metav1.Duration `json:"minInterval,omitempty"`
+	// MaxInterval lowers the longer DNS TTL
+	MaxInterval metav1.Duration `json:"maxInterval,omitempty"`
+	// Cache shares the result of the value used by many networksets, enabled if not set
+	Cache *bool `json:"cache,omitempty"`
+}
+
+// ResolveFailureConfig defines networks of the networkset when the domain is not resolved
+type ResolveFailureConfig struct {
+	// Action is Keep or Empty
+	Action string `json:"action,omitempty"`
+	// GracePeriod is the time the last known good networks are kept
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// ResolversConfig defines the resolvers of the selector labels
+type ResolversConfig struct {
+	DNS  DNSResolverConfig    `json:"dns,omitempty"`
+	HTTP []HTTPResolverConfig `json:"http,omitempty"`
+	File []FileResolverConfig `json:"file,omitempty"`
+}
+
+// DNSResolverConfig defines the selector label resolved by DNS and its upstream servers
+type DNSResolverConfig struct {
+	// Label is the selector label resolved by DNS, DNS_RESOLVER if empty
+	Label string `json:"label,omitempty"`
+	// Servers in host[:port] format, the nameservers of ResolvConf are used if empty
+	Servers    []string `json:"servers,omitempty"`
+	ResolvConf string   `json:"resolvConf,omitempty"`
+}
+
+// HTTPResolverConfig resolves the label by the http url, {value} in the url is replaced by the label value
+type HTTPResolverConfig struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// FileResolverConfig resolves the label by the file named by the label value in the directory
+type FileResolverConfig struct {
+	Label string `json:"label"`
+	Dir   string `json:"dir"`
+}
+
+// KeysConfig defines the label keys and the annotation prefix of the managed networksets
+type KeysConfig struct {
+	ControlPlaneLabel    string `json:"controlPlaneLabel,omitempty"`
+	ControlPlaneValue    string `json:"controlPlaneValue,omitempty"`
+	ParentPolicyLabel    string `json:"parentPolicyLabel,omitempty"`
+	ParentPolicyUIDLabel string `json:"parentPolicyUIDLabel,omitempty"`
+	AnnotationPrefix     string `json:"annotationPrefix,omitempty"`
+}
+
+// ConcurrencyConfig defines the number of concurrent reconciles of the controllers
+type ConcurrencyConfig struct {
+	Policies    int `json:"policies,omitempty"`
+	NetworkSets int `json:"networkSets,omitempty"`
+	DomainSets  int `json:"domainSets,omitempty"`
+}
+
+// NamespacesConfig selects the namespaces of the managed policies, networksets and domainsets.
+// All namespaces are managed if Include is empty, the namespaces in Exclude are never managed
+type NamespacesConfig struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// RequireAllowedDomains denies all domains in the namespaces without the allowed-domains annotation,
+	// otherwise such namespaces may use any domain
+	RequireAllowedDomains bool `json:"requireAllowedDomains,omitempty"`
+}
+
+// Default returns the configuration used when no file is given, it matches the defaults of the flags
+func Default() *ControllerConfig {
+	cfg := &ControllerConfig{
+		TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+	}
+	SetDefaults(cfg)
+	return cfg
+}
+
+// SetDefaults sets the fields which are not set in the file, apiVersion and kind must be set by the file
+func SetDefaults(cfg *ControllerConfig) {
+	if cfg.Refresh.Interval.Duration == 0 {
+		cfg.Refresh.Interval.Duration = 5 * time.Second
+	}
+	if cfg.Refresh.MinInterval.Duration == 0 {
+		cfg.Refresh.MinInterval.Duration = 5 * time.Second
+	}
+	if cfg.Refresh.MaxInterval.Duration == 0 {
+		cfg.Refresh.MaxInterval.Duration = 5 * time.Minute
+	}
+	if cfg.Refresh.Cache == nil {
+		cache := true
+		cfg.Refresh.Cache = &cache
+	}
+	if cfg.ResolveFailure.Action == "" {
+		cfg.ResolveFailure.Action = string(controller.FailureKeep)
+	}
+	if cfg.ResolveFailure.GracePeriod == nil {
+		cfg.ResolveFailure.GracePeriod = &metav1.Duration{Duration: 10 * time.Minute}
+	}
+	if cfg.AddressFamily == "" {
+		cfg.AddressFamily = string(resolver.DualStack)
+	}
+	if cfg.Resolvers.DNS.Label == "" {
+		cfg.Resolvers.DNS.Label = resolver.DNSKey
+	}
+	if len(cfg.Resolvers.DNS.Servers) == 0 && cfg.Resolvers.DNS.ResolvConf == "" {
+		cfg.Resolvers.DNS.ResolvConf = resolver.DefaultResolvConf
+	}
+	keys := controller.DefaultKeys()
+	if cfg.Keys.ControlPlaneLabel == "" {
+		cfg.Keys.ControlPlaneLabel = keys.ControlPlaneLabel
+	}
+	if cfg.Keys.ControlPlaneValue == "" {
+		cfg.Keys.ControlPlaneValue = keys.ControlPlaneValue
+	}
+	if cfg.Keys.ParentPolicyLabel == "" {
+		cfg.Keys.ParentPolicyLabel = keys.ParentPolicyLabel
+	}
+	if cfg.Keys.ParentPolicyUIDLabel == "" {
+		cfg.Keys.ParentPolicyUIDLabel = keys.ParentPolicyUIDLabel
+	}
+	if cfg.Keys.AnnotationPrefix == "" {
+		cfg.Keys.AnnotationPrefix = keys.AnnotationPrefix
+	}
+	for _, concurrency := range []*int{&cfg.Concurrency.Policies, &cfg.Concurrency.NetworkSets, &cfg.Concurrency.DomainSets} {
+		if *concurrency == 0 {
+			*concurrency = 2
+		}
+	}
+}
+
+// Validate returns all errors of the defaulted configuration
+func Validate(cfg *ControllerConfig) error {
+	var allErrs field.ErrorList
+	if cfg.APIVersion != APIVersion {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("apiVersion"), cfg.APIVersion, []string{APIVersion}))
+	}
+	if cfg.Kind != Kind {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("kind"), cfg.Kind, []string{Kind}))
+	}
+
+	refreshPath := field.NewPath("refresh")
+	for name, interval := range map[string]time.Duration{
+		"interval":    cfg.Refresh.Interval.Duration,
+		"minInterval": cfg.Refresh.MinInterval.Duration,
+		"maxInterval": cfg.Refresh.MaxInterval.Duration,
+	} {
+		if interval < 0 {
+			allErrs = append(allErrs, field.Invalid(refreshPath.Child(name), interval.String(), "must not be negative"))
+		}
+	}
+	if cfg.Refresh.MinInterval.Duration > cfg.Refresh.MaxInterval.Duration {
+		allErrs = append(allErrs, field.Invalid(refreshPath.Child("minInterval"), cfg.Refresh.MinInterval.Duration.String(),
+			"must not be greater than maxInterval"))
+	}
+
+	failurePath := field.NewPath("resolveFailure")
+	if _, err := controller.ParseFailureAction(cfg.ResolveFailure.Action); err != nil {
+		allErrs = append(allErrs, field.NotSupported(failurePath.Child("action"), cfg.ResolveFailure.Action,
+			[]string{string(controller.FailureKeep), string(controller.FailureEmpty)}))
+	}
+	if cfg.ResolveFailure.GracePeriod != nil && cfg.ResolveFailure.GracePeriod.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(failurePath.Child("gracePeriod"), cfg.ResolveFailure.GracePeriod.Duration.String(), "must not be negative"))
+	}
+	if _, err := resolver.ParseAddressFamily(cfg.AddressFamily); err != nil {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("addressFamily"), cfg.AddressFamily,
+			[]string{string(resolver.IPv4), string(resolver.IPv6), string(resolver.DualStack)}))
+	}
+
+	allErrs = append(allErrs, validateResolvers(cfg.Resolvers, field.NewPath("resolvers"))...)
+	allErrs = append(allErrs, validateKeys(cfg.Keys, field.NewPath("keys"))...)
+
+	concurrencyPath := field.NewPath("concurrency")
+	for name, concurrency := range map[string]int{
+		"policies":    cfg.Concurrency.Policies,
+		"networkSets": cfg.Concurrency.NetworkSets,
+		"domainSets":  cfg.Concurrency.DomainSets,
+	} {
+		if concurrency < 1 {
+			allErrs = append(allErrs, field.Invalid(concurrencyPath.Child(name), concurrency, "must be at least 1"))
+		}
+	}
+
+	namespacesPath := field.NewPath("namespaces")
+	for name, namespaces := range map[string][]string{
+		"include": cfg.Namespaces.Include,
+		"exclude": cfg.Namespaces.Exclude,
+	} {
+		for i, namespace := range namespaces {
+			for _, msg := range validation.IsDNS1123Label(namespace) {
+				allErrs = append(allErrs, field.Invalid(namespacesPath.Child(name).Index(i), namespace, msg))
+			}
+		}
+	}
+
+	return allErrs.ToAggregate()
+}
+
+func validateResolvers(resolvers ResolversConfig, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	// the DNS label can be replaced by the http or file resolver
+	labels := map[string]bool{}
+	validateLabel := func(label string, path *field.Path) {
+		if label == "" {
+			allErrs = append(allErrs, field.Required(path, ""))
+			return
+		}
+		for _, msg := range validation.IsQualifiedName(label) {
+			allErrs = append(allErrs, field.Invalid(path, label, msg))
+		}
+		if labels[label] {
+			allErrs = append(allErrs, field.Duplicate(path, label))
+		}
+		labels[label] = true
+	}
+	for _, msg := range validation.IsQualifiedName(resolvers.DNS.Label) {
+		allErrs = append(allErrs, field.Invalid(path.Child("dns", "label"), resolvers.DNS.Label, msg))
+	}
+	for i, server := range resolvers.DNS.Servers {
+		if server == "" {
+			allErrs = append(allErrs, field.Required(path.Child("dns", "servers").Index(i), ""))
+		}
+	}
+	for i, http := range resolvers.HTTP {
+		validateLabel(http.Label, path.Child("http").Index(i).Child("label"))
+		if u, err := url.Parse(strings.ReplaceAll(http.URL, "{value}", "value")); err != nil || u.Scheme == "" || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(path.Child("http").Index(i).Child("url"), http.URL, "must be an absolute http url"))
+		}
+	}
+	for i, file := range resolvers.File {
+		validateLabel(file.Label, path.Child("file").Index(i).Child("label"))
+		if file.Dir == "" {
+			allErrs = append(allErrs, field.Required(path.Child("file").Index(i).Child("dir"), ""))
+		}
+	}
+	return allErrs
+}
+
+func validateKeys(keys KeysConfig, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for name, key := range map[string]string{
+		"controlPlaneLabel":    keys.ControlPlaneLabel,
+		"parentPolicyLabel":    keys.ParentPolicyLabel,
+		"parentPolicyUIDLabel": keys.ParentPolicyUIDLabel,
+	} {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(path.Child(name), key, msg))
+		}
+	}
+	for _, msg := range validation.IsValidLabelValue(keys.ControlPlaneValue) {
+		allErrs = append(allErrs, field.Invalid(path.Child("controlPlaneValue"), keys.ControlPlaneValue, msg))
+	}
+	// the prefix is followed by the names like address-family
+	for _, msg := range validation.IsQualifiedName(keys.AnnotationPrefix + "address-family") {
+		allErrs = append(allErrs, field.Invalid(path.Child("annotationPrefix"), keys.AnnotationPrefix, msg))
+	}
+	if !strings.HasSuffix(keys.AnnotationPrefix, "/") {
+		allErrs = append(allErrs, field.Invalid(path.Child("annotationPrefix"), keys.AnnotationPrefix, "must end with /"))
+	}
+	return allErrs
+}
+
+// Parse decodes, defaults and validates the configuration, unknown fields are rejected
+func Parse(data []byte) (*ControllerConfig, error) {
+	cfg := &ControllerConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("cannot decode configuration: %w", err)
+	}
+	SetDefaults(cfg)
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// SplitList splits the comma separated flag value, the spaces around the items and the empty items are dropped
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Load reads the configuration file
+func Load(path string) (*ControllerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/api/equality"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var configLog = ctrl.Log.WithName("config")
+
+// Watcher reloads the configuration file when it changes. The directory of the file is watched,
+// the file of the mounted ConfigMap is replaced by the symlink swap and is not changed in place
+type Watcher struct {
+	// Path of the configuration file
+	Path string
+	// OnChange applies the reloaded configuration when it is valid and differs from the current one,
+	// the configuration which is not applied is loaded again on the next change of the file
+	OnChange func(cfg *ControllerConfig) error
+
+	current *ControllerConfig
+}
+
+// NewWatcher creates the watcher of the file loaded with the current configuration
+func NewWatcher(path string, current *ControllerConfig, onChange func(cfg *ControllerConfig) error) *Watcher {
+	return &Watcher{
+		Path:     path,
+		OnChange: onChange,
+		current:  current,
+	}
+}
+
+// Start watches the file until the context is done, it implements manager.Runnable
+func (w *Watcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return err
+	}
+	configLog.Info("watching configuration file", "path", w.Path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			w.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			configLog.Error(err, "cannot watch configuration file", "path", w.Path)
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica reloads its configuration
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload loads the file and calls OnChange when the configuration is changed,
+// the invalid configuration and the configuration which is not applied are reported and the current configuration is kept
+func (w *Watcher) reload() {
+	cfg, err := Load(w.Path)
+	if err != nil {
+		configLog.Error(err, "cannot reload configuration, the current configuration is kept", "path", w.Path)
+		return
+	}
+	if equality.Semantic.DeepEqual(cfg, w.current) {
+		return
+	}
+	if err := w.OnChange(cfg); err != nil {
+		configLog.Error(err, "cannot apply reloaded configuration, the current configuration is kept", "path", w.Path)
+		return
+	}
+	w.current = cfg
+	configLog.Info("configuration is reloaded", "path", w.Path)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWatcherKeepsConfigurationNotApplied(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("apiVersion: "+APIVersion+"\nkind: "+Kind+"\naddressFamily: IPv4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	applied := 0
+	fail := true
+	w := NewWatcher(path, Default(), func(cfg *ControllerConfig) error {
+		if fail {
+			return errors.New("cannot apply")
+		}
+		applied++
+		return nil
+	})
+
+	w.reload()
+	if w.current.AddressFamily == "IPv4" {
+		t.Errorf("configuration which is not applied is current")
+	}
+	fail = false
+	w.reload()
+	w.reload()
+	if applied != 1 || w.current.AddressFamily != "IPv4" {
+		t.Errorf("configuration is applied %d times, address family %s, expected once and IPv4", applied, w.current.AddressFamily)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	for value, expected := range map[string][]string{
+		"":                            nil,
+		"10.0.0.10":                   {"10.0.0.10"},
+		"10.0.0.10, 10.0.0.11:53 ,":   {"10.0.0.10", "10.0.0.11:53"},
+		" example.com ,, github.com ": {"example.com", "github.com"},
+	} {
+		if items := SplitList(value); !reflect.DeepEqual(items, expected) {
+			t.Errorf("SplitList(%q) = %q, expected %q", value, items, expected)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+)
+
+var (
+	// accumulateAnnotation enables accumulation of the networks, the value is the retention window,
+	// networks stay in the networkset for the window after they were resolved last time
+	accumulateAnnotation = annotationPrefix + "accumulate"
+	// maxAddressesAnnotation limits the number of accumulated networks, the most recently seen are kept
+	maxAddressesAnnotation = annotationPrefix + "max-addresses"
+	// lastSeenAnnotation is the JSON map of the accumulated networks to the time they were resolved last time
+	lastSeenAnnotation = annotationPrefix + "last-seen"
+)
+
+// lastSeenPrecision is the fraction of the retention window the last seen time is refreshed with
+const lastSeenPrecision = 10
+
+// accumulateNetworks adds the networks seen during the retention window to the resolved networks
+// when the accumulation is enabled by the annotations. It returns the networks and the time
+// the next network expires, the zero time is returned when no network expires
+func accumulateNetworks(annotations map[string]string, current []string, resolved []string, family resolver.AddressFamily, now time.Time) ([]string, time.Time) {
+	value, ok := annotations[accumulateAnnotation]
+	if !ok {
+		delete(annotations, lastSeenAnnotation)
+		return resolved, time.Time{}
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		controllerNetworksetsLog.Error(err, "invalid annotation", "annotation", accumulateAnnotation, "value", value)
+		delete(annotations, lastSeenAnnotation)
+		return resolved, time.Time{}
+	}
+
+	lastSeen := map[string]time.Time{}
+	if value, ok := annotations[lastSeenAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &lastSeen); err != nil {
+			controllerNetworksetsLog.Error(err, "invalid annotation", "annotation", lastSeenAnnotation)
+			lastSeen = map[string]time.Time{}
+		}
+	}
+	// networks added before the accumulation was enabled are seen now
+	seenAt := now.Truncate(time.Second)
+	for _, network := range current {
+		if _, ok := lastSeen[network]; !ok {
+			lastSeen[network] = seenAt
+		}
+	}
+	// the time is not refreshed on every resolve to avoid updating the networkset every time
+	for _, network := range resolved {
+		if seen, ok := lastSeen[network]; !ok || now.Sub(seen) >= retention/lastSeenPrecision {
+			lastSeen[network] = seenAt
+		}
+	}
+
+	networks := make([]string, 0, len(lastSeen))
+	for network, seen := range lastSeen {
+		prefix, err := resolver.ParsePrefix(network)
+		if err != nil || len(family.Filter([]netip.Prefix{prefix})) == 0 || now.Sub(seen) >= retention {
+			delete(lastSeen, network)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	// the most recently seen networks are kept
+	sort.Slice(networks, func(i, j int) bool {
+		if !lastSeen[networks[i]].Equal(lastSeen[networks[j]]) {
+			return lastSeen[networks[i]].After(lastSeen[networks[j]])
+		}
+		return networks[i] < networks[j]
+	})
+	if maxAddresses := getMaxAddresses(annotations); maxAddresses > 0 && len(networks) > maxAddresses {
+		for _, network := range networks[maxAddresses:] {
+			delete(lastSeen, network)
+		}
+		networks = networks[:maxAddresses]
+	}
+
+	var expires time.Time
+	for _, seen := range lastSeen {
+		if expires.IsZero() || seen.Add(retention).Before(expires) {
+			expires = seen.Add(retention)
+		}
+	}
+	encoded, err := json.Marshal(lastSeen)
+	if err != nil {
+		controllerNetworksetsLog.Error(err, "cannot encode annotation", "annotation", lastSeenAnnotation)
+		return resolved, time.Time{}
+	}
+	annotations[lastSeenAnnotation] = string(encoded)
+	sort.Strings(networks)
+
+	return networks, expires
+}
+
+// getMaxAddresses returns the limit of accumulated networks, zero if the networks are not limited
+func getMaxAddresses(annotations map[string]string) int {
+	value, ok := annotations[maxAddressesAnnotation]
+	if !ok {
+		return 0
+	}
+	maxAddresses, err := strconv.Atoi(value)
+	if err != nil || maxAddresses < 0 {
+		controllerNetworksetsLog.Error(err, "invalid annotation", "annotation", maxAddressesAnnotation, "value", value)
+		return 0
+	}
+	return maxAddresses
+}
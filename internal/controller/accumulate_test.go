@@ -0,0 +1,121 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+)
+
+func TestAccumulateNetworks(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	lastSeen := func(seen map[string]time.Duration) string {
+		times := map[string]time.Time{}
+		for network, age := range seen {
+			times[network] = now.Add(-age)
+		}
+		data, _ := json.Marshal(times)
+		return string(data)
+	}
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		current     []string
+		resolved    []string
+		family      resolver.AddressFamily
+		networks    []string
+		expires     time.Time
+	}{
+		{
+			name:     "disabled",
+			current:  []string{"192.0.2.1/32"},
+			resolved: []string{"192.0.2.2/32"},
+			networks: []string{"192.0.2.2/32"},
+		},
+		{
+			name:        "invalid retention",
+			annotations: map[string]string{accumulateAnnotation: "forever"},
+			current:     []string{"192.0.2.1/32"},
+			resolved:    []string{"192.0.2.2/32"},
+			networks:    []string{"192.0.2.2/32"},
+		},
+		{
+			name:        "current networks are kept",
+			annotations: map[string]string{accumulateAnnotation: "1h"},
+			current:     []string{"192.0.2.1/32"},
+			resolved:    []string{"192.0.2.2/32"},
+			networks:    []string{"192.0.2.1/32", "192.0.2.2/32"},
+			expires:     now.Add(time.Hour),
+		},
+		{
+			name: "networks expire after retention",
+			annotations: map[string]string{accumulateAnnotation: "1h",
+				lastSeenAnnotation: lastSeen(map[string]time.Duration{"192.0.2.1/32": 2 * time.Hour, "192.0.2.3/32": 30 * time.Minute})},
+			current:  []string{"192.0.2.1/32", "192.0.2.3/32"},
+			resolved: []string{"192.0.2.2/32"},
+			networks: []string{"192.0.2.2/32", "192.0.2.3/32"},
+			expires:  now.Add(30 * time.Minute),
+		},
+		{
+			name: "resolved networks are seen again",
+			annotations: map[string]string{accumulateAnnotation: "1h",
+				lastSeenAnnotation: lastSeen(map[string]time.Duration{"192.0.2.1/32": 50 * time.Minute})},
+			current:  []string{"192.0.2.1/32"},
+			resolved: []string{"192.0.2.1/32"},
+			networks: []string{"192.0.2.1/32"},
+			expires:  now.Add(time.Hour),
+		},
+		{
+			name: "most recently seen networks are kept",
+			annotations: map[string]string{accumulateAnnotation: "1h", maxAddressesAnnotation: "2",
+				lastSeenAnnotation: lastSeen(map[string]time.Duration{"192.0.2.1/32": 40 * time.Minute, "192.0.2.3/32": 20 * time.Minute})},
+			current:  []string{"192.0.2.1/32", "192.0.2.3/32"},
+			resolved: []string{"192.0.2.2/32"},
+			networks: []string{"192.0.2.2/32", "192.0.2.3/32"},
+			expires:  now.Add(40 * time.Minute),
+		},
+		{
+			name:        "networks of other family are removed",
+			annotations: map[string]string{accumulateAnnotation: "1h"},
+			current:     []string{"2001:db8::1/128"},
+			resolved:    []string{"192.0.2.1/32"},
+			family:      resolver.IPv4,
+			networks:    []string{"192.0.2.1/32"},
+			expires:     now.Add(time.Hour),
+		},
+	} {
+		annotations := tc.annotations
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		family := tc.family
+		if family == "" {
+			family = resolver.DualStack
+		}
+		networks, expires := accumulateNetworks(annotations, tc.current, tc.resolved, family, now)
+		if !reflect.DeepEqual(networks, tc.networks) {
+			t.Errorf("%s: networks are %v, expected %v", tc.name, networks, tc.networks)
+		}
+		if !expires.Equal(tc.expires) {
+			t.Errorf("%s: networks expire at %v, expected %v", tc.name, expires, tc.expires)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/javdet/networksets-controller/internal/selector"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// allowedDomainsAnnotation of the namespace lists the selector values the policies, networksets and domainsets
+// of the namespace may resolve. The entries are separated by commas or whitespace, every entry is [LABEL=]PATTERN.
+// The pattern is the domain, *.domain matching its subdomains but not the domain itself or * matching any value,
+// the entry without the label applies to all resolver labels. The cluster-scoped policies, networksets and domainsets
+// are not limited by the allowlist
+var allowedDomainsAnnotation = annotationPrefix + "allowed-domains"
+
+// reasonDomainNotAllowed is the reason of the events of the values denied by the allowlist
+const reasonDomainNotAllowed = "DomainNotAllowed"
+
+// domainAllowlist is the allowlist of the namespace, all values are allowed if it is not restricted
+type domainAllowlist struct {
+	restricted bool
+	entries    []allowlistEntry
+}
+
+// allowlistEntry allows the values matching the pattern of the label, all labels if the label is empty
+type allowlistEntry struct {
+	label   string
+	pattern string
+}
+
+// parseAllowlist parses the value of the allowed-domains annotation
+func parseAllowlist(value string) domainAllowlist {
+	allowlist := domainAllowlist{restricted: true}
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		label, pattern, ok := strings.Cut(entry, "=")
+		if !ok {
+			label, pattern = "", entry
+		}
+		allowlist.entries = append(allowlist.entries, allowlistEntry{label: label, pattern: normalizeDomain(pattern)})
+	}
+	return allowlist
+}
+
+// allows reports whether the value of the resolver label is allowed
+func (a domainAllowlist) allows(label string, value string) bool {
+	if !a.restricted {
+		return true
+	}
+	value = normalizeDomain(value)
+	for _, entry := range a.entries {
+		if entry.label != "" && entry.label != label {
+			continue
+		}
+		switch {
+		case entry.pattern == "*":
+			return true
+		case strings.HasPrefix(entry.pattern, "*."):
+			if strings.HasSuffix(value, entry.pattern[1:]) {
+				return true
+			}
+		case entry.pattern == value:
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomain compares domains case insensitive and without the trailing dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// namespaceAllowlist reads the allowlist of the namespace. The namespace without the annotation is restricted
+// when the allowlist is required, nothing is allowed then
+func namespaceAllowlist(ctx context.Context, c client.Reader, namespace string, required bool) (domainAllowlist, error) {
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return domainAllowlist{}, fmt.Errorf("cannot get namespace %s: %w", namespace, err)
+	}
+	value, ok := ns.GetAnnotations()[allowedDomainsAnnotation]
+	if !ok {
+		return domainAllowlist{restricted: required}, nil
+	}
+	return parseAllowlist(value), nil
+}
+
+// allowedTerms returns the terms allowed in the namespace of the policy, the denied terms are recorded on the policy.
+// The networksets of the denied terms are removed as unused
+func (r *NetworkPolicyReconciler) allowedTerms(ctx context.Context, instance *calicov3.NetworkPolicy, terms []selector.Term, required bool) ([]selector.Term, error) {
+	allowlist, err := namespaceAllowlist(ctx, r.Client, instance.GetNamespace(), required)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []selector.Term
+	for _, term := range terms {
+		if allowlist.allows(term.Key, term.Value) {
+			allowed = append(allowed, term)
+			continue
+		}
+		controllerNetworksetsLog.Info("Domain is not allowed in the namespace", "namespace", instance.GetNamespace(), "name", instance.GetName(), "label", term.Key, "domain", term.Value)
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, reasonDomainNotAllowed, "%s == '%s' is not allowed in namespace %s", term.Key, term.Value, instance.GetNamespace())
+	}
+	return allowed, nil
+}
+
+// allowlistChangedPredicate passes the namespaces whose allowed-domains annotation is changed
+var allowlistChangedPredicate = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldValue, oldOk := e.ObjectOld.GetAnnotations()[allowedDomainsAnnotation]
+		newValue, newOk := e.ObjectNew.GetAnnotations()[allowedDomainsAnnotation]
+		return oldOk != newOk || oldValue != newValue
+	},
+	DeleteFunc: func(event.DeleteEvent) bool {
+		return false
+	},
+	GenericFunc: func(event.GenericEvent) bool {
+		return false
+	},
+}
+
+// enqueueNamespaceObjects returns the handler reconciling the objects of the list in the namespace
+// when the allowlist of the namespace is changed
+func enqueueNamespaceObjects(c client.Reader, list client.ObjectList, opts ...client.ListOption) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, namespace client.Object) []reconcile.Request {
+		objects, ok := list.DeepCopyObject().(client.ObjectList)
+		if !ok {
+			return nil
+		}
+		if err := c.List(ctx, objects, append([]client.ListOption{client.InNamespace(namespace.GetName())}, opts...)...); err != nil {
+			controllerNetworksetsLog.Error(err, "cannot list objects of the namespace", "namespace", namespace.GetName())
+			return nil
+		}
+		var requests []reconcile.Request
+		_ = meta.EachListItem(objects, func(obj runtime.Object) error {
+			if object, ok := obj.(client.Object); ok {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(object)})
+			}
+			return nil
+		})
+		return requests
+	})
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAllowlistAllows(t *testing.T) {
+	for _, tc := range []struct {
+		annotation string
+		label      string
+		value      string
+		allowed    bool
+	}{
+		{annotation: "", label: "DNS_RESOLVER", value: "example.com", allowed: false},
+		{annotation: "*", label: "DNS_RESOLVER", value: "example.com", allowed: true},
+		{annotation: "api.example.com", label: "DNS_RESOLVER", value: "api.example.com", allowed: true},
+		{annotation: "api.example.com", label: "DNS_RESOLVER", value: "API.Example.com.", allowed: true},
+		{annotation: "api.example.com.", label: "DNS_RESOLVER", value: "api.example.com", allowed: true},
+		{annotation: "api.example.com", label: "DNS_RESOLVER", value: "www.example.com", allowed: false},
+		{annotation: "*.example.com", label: "DNS_RESOLVER", value: "api.example.com", allowed: true},
+		{annotation: "*.example.com", label: "DNS_RESOLVER", value: "a.b.example.com", allowed: true},
+		{annotation: "*.example.com", label: "DNS_RESOLVER", value: "example.com", allowed: false},
+		{annotation: "*.example.com", label: "DNS_RESOLVER", value: "badexample.com", allowed: false},
+		{annotation: "example.com, *.example.com", label: "DNS_RESOLVER", value: "example.com", allowed: true},
+		{annotation: "HTTP_RESOLVER=*", label: "HTTP_RESOLVER", value: "web-prod", allowed: true},
+		{annotation: "HTTP_RESOLVER=*", label: "DNS_RESOLVER", value: "example.com", allowed: false},
+		{annotation: "DNS_RESOLVER=example.com\tHTTP_RESOLVER=web\n", label: "HTTP_RESOLVER", value: "web", allowed: true},
+	} {
+		if allowed := parseAllowlist(tc.annotation).allows(tc.label, tc.value); allowed != tc.allowed {
+			t.Errorf("allowlist %q allows %s == '%s': %v, expected %v", tc.annotation, tc.label, tc.value, allowed, tc.allowed)
+		}
+	}
+	if !(domainAllowlist{}).allows("DNS_RESOLVER", "example.com") {
+		t.Errorf("namespace without allowlist does not allow the domain")
+	}
+}
+
+func TestAllowlistClusterScoped(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := calicov3.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a",
+		Annotations: map[string]string{allowedDomainsAnnotation: "*.example.com"}}}
+	resolvers := resolver.NewRegistry()
+	resolvers.Register(resolver.DNSKey, resolver.NewDNSResolver(nil))
+	v := &PolicyValidator{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace).Build(),
+		Resolvers: resolvers,
+		Settings:  NewSettingsStore(Settings{}),
+	}
+	rules := []calicov3.Rule{{Action: calicov3.Allow, Destination: calicov3.EntityRule{Selector: "DNS_RESOLVER == 'example.com'"}}}
+	resolves, cancel := v.newRequestResolves(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		policy runtime.Object
+		denied bool
+	}{
+		{policy: &calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "policy"},
+			Spec: calicov3.NetworkPolicySpec{Egress: rules}}, denied: true},
+		{policy: &calicov3.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+			Spec: calicov3.GlobalNetworkPolicySpec{Egress: rules}}, denied: false},
+	} {
+		warnings, err := v.validate(context.Background(), tc.policy, resolves)
+		if err != nil {
+			t.Fatalf("%T is rejected: %v", tc.policy, err)
+		}
+		denied := false
+		for _, warning := range warnings {
+			denied = denied || strings.Contains(warning, "is not allowed in the namespace")
+		}
+		if denied != tc.denied {
+			t.Errorf("%T: domain is denied %v, expected %v, warnings %q", tc.policy, denied, tc.denied, warnings)
+		}
+	}
+}
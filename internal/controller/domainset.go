@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/javdet/networksets-controller/api/v1alpha1"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// domainSetLabel references the domainset of the networkset, it can be used in the policy selectors
+var domainSetLabel = annotationPrefix + "domainset"
+
+// errTooFewAddresses is returned when fewer networks than minAddresses of the domainset are resolved
+var errTooFewAddresses = errors.New("too few addresses")
+
+// domainSetSources returns the sources of the domainset, the domains are resolved by the DNS label
+func domainSetSources(spec v1alpha1.DomainSetSpec, dnsKey string) []v1alpha1.DomainSetSource {
+	sources := make([]v1alpha1.DomainSetSource, 0, len(spec.Domains)+len(spec.Sources))
+	for _, domain := range spec.Domains {
+		sources = append(sources, v1alpha1.DomainSetSource{Resolver: dnsKey, Value: domain})
+	}
+	return append(sources, spec.Sources...)
+}
+
+// getDomainSetFamily returns address family of the domainset or the default one
+func getDomainSetFamily(spec v1alpha1.DomainSetSpec, defaultFamily resolver.AddressFamily) resolver.AddressFamily {
+	if spec.AddressFamily == "" {
+		return defaultFamily
+	}
+	family, err := resolver.ParseAddressFamily(spec.AddressFamily)
+	if err != nil {
+		return defaultFamily
+	}
+	return family
+}
+
+// resolveDomainSet resolves all sources of the domainset and returns their networks and the shortest TTL,
+// the errors of all failed sources are returned joined
+func resolveDomainSet(ctx context.Context, resolvers *resolver.Registry, spec v1alpha1.DomainSetSpec, family resolver.AddressFamily) ([]string, time.Duration, error) {
+	var prefixes []netip.Prefix
+	var minTTL time.Duration
+	var errs []error
+	for _, source := range domainSetSources(spec, resolvers.DNSKey()) {
+		resolved, ttl, err := resolvers.ResolveTTL(ctx, source.Resolver, source.Value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot resolve %s %s: %w", source.Resolver, source.Value, err))
+			continue
+		}
+		if ttl > 0 && (minTTL == 0 || ttl < minTTL) {
+			minTTL = ttl
+		}
+		prefixes = append(prefixes, resolved...)
+	}
+	if len(errs) > 0 {
+		return nil, 0, errors.Join(errs...)
+	}
+
+	networks, err := canonicalPrefixes(resolver.FormatPrefixes(family.Filter(prefixes)))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(networks) < int(spec.MinAddresses) {
+		return nil, 0, fmt.Errorf("%w: %d resolved, at least %d required", errTooFewAddresses, len(networks), spec.MinAddresses)
+	}
+
+	return resolver.FormatPrefixes(networks), minTTL, nil
+}
+
+// getDomainSetLabels returns labels of the domainset with the label referencing the domainset.
+// The labels of the controller and the resolver labels are not copied, the policy selectors and the controllers
+// would take the networkset for the networkset of the policy otherwise
+func getDomainSetLabels(domainSet metav1.Object, resolvers *resolver.Registry) map[string]string {
+	labels := map[string]string{}
+	for key, value := range domainSet.GetLabels() {
+		switch key {
+		case controlPlaneLabel, parentPolicyLabel, parentPolicyUIDLabel, domainSetLabel:
+			continue
+		}
+		if _, ok := resolvers.Get(key); ok {
+			continue
+		}
+		labels[key] = value
+	}
+	labels[domainSetLabel] = domainSet.GetName()
+	return labels
+}
+
+// updateDomainSetAnnotations copies options of the domainset to the networkset annotations
+func updateDomainSetAnnotations(annotations map[string]string, spec v1alpha1.DomainSetSpec) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations["operator"] = "networksets"
+	if spec.Accumulate != nil {
+		annotations[accumulateAnnotation] = spec.Accumulate.Duration.String()
+	} else {
+		delete(annotations, accumulateAnnotation)
+	}
+	if spec.MaxAddresses > 0 {
+		annotations[maxAddressesAnnotation] = strconv.Itoa(int(spec.MaxAddresses))
+	} else {
+		delete(annotations, maxAddressesAnnotation)
+	}
+	return annotations
+}
+
+// setDomainSetStatus records the networks of the networkset and the result of the resolve in the status.
+// The refresh time is recorded when the networks or the condition are changed, so the status is not written
+// on every resolve which returns the same networks
+func setDomainSetStatus(status *v1alpha1.DomainSetStatus, generation int64, networks []string, resolveErr error, now time.Time) {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             v1alpha1.ReasonResolved,
+		Message:            fmt.Sprintf("%d addresses resolved", len(networks)),
+	}
+	switch {
+	case errors.Is(resolveErr, errTooFewAddresses):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = v1alpha1.ReasonTooFewAddresses
+		condition.Message = resolveErr.Error()
+	case resolveErr != nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = v1alpha1.ReasonResolveFailed
+		// the errors of the sources are joined by newlines
+		condition.Message = strings.ReplaceAll(resolveErr.Error(), "\n", "; ")
+	}
+	previous := meta.FindStatusCondition(status.Conditions, v1alpha1.ConditionReady)
+	changed := previous == nil || previous.Status != condition.Status || previous.Reason != condition.Reason ||
+		previous.Message != condition.Message || previous.ObservedGeneration != generation ||
+		status.ObservedGeneration != generation || !slices.Equal(status.Addresses, networks)
+	status.ObservedGeneration = generation
+	status.Addresses = networks
+	if resolveErr == nil && (changed || status.LastRefreshTime == nil) {
+		status.LastRefreshTime = &metav1.Time{Time: now}
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// deniedSources returns the sources which are not allowed by the allowlist in LABEL == 'VALUE' form
+func deniedSources(allowlist domainAllowlist, sources []v1alpha1.DomainSetSource) []string {
+	var denied []string
+	for _, source := range sources {
+		if !allowlist.allows(source.Resolver, source.Value) {
+			denied = append(denied, fmt.Sprintf("%s == '%s'", source.Resolver, source.Value))
+		}
+	}
+	return denied
+}
+
+// setDomainSetDenied records the sources which are not allowed in the namespace in the status
+func setDomainSetDenied(status *v1alpha1.DomainSetStatus, generation int64, namespace string, denied []string) {
+	status.ObservedGeneration = generation
+	status.Addresses = nil
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             v1alpha1.ReasonDomainNotAllowed,
+		Message:            fmt.Sprintf("%s not allowed in namespace %s", strings.Join(denied, ", "), namespace),
+	})
+}
+
+// setDomainSetFailed records the error of writing the networkset in the status
+func setDomainSetFailed(status *v1alpha1.DomainSetStatus, generation int64, err error) {
+	status.ObservedGeneration = generation
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             v1alpha1.ReasonNetworkSetFailed,
+		Message:            err.Error(),
+	})
+}
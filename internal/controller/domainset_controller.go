@@ -0,0 +1,247 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/api/v1alpha1"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"github.com/javdet/networksets-controller/monitoring"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// DomainSetReconciler resolves DomainSet to the NetworkSet with the same name
+type DomainSetReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Resolvers *resolver.Registry
+	// Settings are loaded at the start of every reconcile, they are replaced when the configuration is reloaded
+	Settings *SettingsStore
+	// MaxConcurrentReconciles is the number of concurrent reconciles, 2 if not set
+	MaxConcurrentReconciles int
+
+	schedule refreshSchedule
+}
+
+var controllerDomainSetLog = ctrl.Log.WithName("controller").WithName("DomainSet")
+
+//+kubebuilder:rbac:groups=networksets.javdet.io,resources=domainsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networksets.javdet.io,resources=domainsets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networksets.javdet.io,resources=domainsets/finalizers,verbs=update
+
+// Reconcile resolves domains and sources of the domainset and writes the networks to its networkset.
+// The domainset is requeued when the records TTL expires, failed resolves are retried with backoff
+func (r *DomainSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	controllerDomainSetLog.Info("start reconcile", "request", req.NamespacedName)
+	settings := r.Settings.Load()
+	if !settings.Namespaces.Allowed(req.Namespace) {
+		return ctrl.Result{}, nil
+	}
+	key := req.NamespacedName.String()
+
+	domainSet := &v1alpha1.DomainSet{}
+	err := r.Get(ctx, req.NamespacedName, domainSet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the networkset is deleted by the garbage collector
+			r.schedule.forget(key)
+			return ctrl.Result{}, nil
+		}
+		controllerDomainSetLog.Error(err, "cannot get object DomainSet")
+		return ctrl.Result{}, err
+	}
+
+	networkSet := &calicov3.NetworkSet{}
+	err = r.Get(ctx, req.NamespacedName, networkSet)
+	if client.IgnoreNotFound(err) != nil {
+		controllerDomainSetLog.Error(err, "cannot get object NetworkSet")
+		return ctrl.Result{}, err
+	}
+	exists := err == nil
+	now := time.Now()
+	if exists && !metav1.IsControlledBy(networkSet, domainSet) {
+		err = fmt.Errorf("networkset %s is not managed by the domainset", req.NamespacedName)
+		controllerDomainSetLog.Error(err, "cannot write NetworkSet")
+		setDomainSetFailed(&domainSet.Status, domainSet.GetGeneration(), err)
+		retry := r.schedule.failed(key, now, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		return ctrl.Result{RequeueAfter: retry}, r.Status().Update(ctx, domainSet)
+	}
+
+	allowlist, err := namespaceAllowlist(ctx, r.Client, req.Namespace, settings.RequireAllowedDomains)
+	if err != nil {
+		controllerDomainSetLog.Error(err, "cannot get allowed domains", "request", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+	if denied := deniedSources(allowlist, domainSetSources(domainSet.Spec, r.Resolvers.DNSKey())); len(denied) > 0 {
+		r.schedule.forget(key)
+		return ctrl.Result{}, r.denyDomainSet(ctx, domainSet, networkSet, exists, denied)
+	}
+
+	// changes of the spec, the deleted networkset and the networkset edited manually are resolved at once,
+	// other events wait for the scheduled time
+	drifted := netsDrifted(networkSet.GetAnnotations(), networkSet.Spec.Nets) || !maps.Equal(networkSet.GetLabels(), getDomainSetLabels(domainSet, r.Resolvers))
+	if exists && domainSet.Status.ObservedGeneration == domainSet.GetGeneration() && !drifted {
+		if remaining := r.schedule.remaining(key, now); remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	family := getDomainSetFamily(domainSet.Spec, settings.AddressFamily)
+	resolved, ttl, resolveErr := resolveDomainSet(ctx, r.Resolvers, domainSet.Spec, family)
+	var interval time.Duration
+	if resolveErr != nil {
+		interval = r.schedule.failed(key, now, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		controllerDomainSetLog.Error(resolveErr, "cannot resolve domainset, retry later", "request", req.NamespacedName, "retry", interval)
+	} else {
+		if ttl == 0 {
+			ttl = settings.RefreshInterval
+			if domainSet.Spec.RefreshInterval != nil {
+				ttl = domainSet.Spec.RefreshInterval.Duration
+			}
+		}
+		interval = refreshInterval(ttl, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+	}
+
+	if !exists {
+		networkSet = &calicov3.NetworkSet{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetworkSet",
+				APIVersion: "projectcalico.org/v3",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      domainSet.GetName(),
+				Namespace: domainSet.GetNamespace(),
+			},
+		}
+	}
+	original := networkSet.DeepCopy()
+	annotations := updateDomainSetAnnotations(maps.Clone(networkSet.GetAnnotations()), domainSet.Spec)
+	networks, expires := managedNets(annotations, original.Spec.Nets, resolved, resolveErr, family, settings.FailurePolicy, now)
+	if resolveErr == nil {
+		// accumulated networks are removed when they expire
+		if !expires.IsZero() && expires.Sub(now) < interval {
+			interval = refreshInterval(expires.Sub(now), settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		}
+		r.schedule.succeeded(key, now.Add(interval))
+	}
+	networkSet.SetLabels(getDomainSetLabels(domainSet, r.Resolvers))
+	networkSet.SetAnnotations(annotations)
+	networkSet.SetOwnerReferences(updateControllerRef(networkSet.GetOwnerReferences(),
+		metav1.NewControllerRef(domainSet, v1alpha1.GroupVersion.WithKind("DomainSet"))))
+	networkSet.Spec.Nets = networks
+
+	if !exists {
+		controllerDomainSetLog.Info("Create networkset", "request", req.NamespacedName)
+		err = r.Create(ctx, networkSet)
+		if err != nil {
+			controllerDomainSetLog.Error(err, "cannot create NetworkSet", "request", req.NamespacedName)
+			monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationCreate)
+			r.schedule.forget(key)
+			setDomainSetFailed(&domainSet.Status, domainSet.GetGeneration(), err)
+			if statusErr := r.Status().Update(ctx, domainSet); statusErr != nil {
+				controllerDomainSetLog.Error(statusErr, "cannot update DomainSet status", "request", req.NamespacedName)
+			}
+			return ctrl.Result{}, err
+		}
+		monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationCreate)
+	} else {
+		match, err := arraysMatch(networkSet.Spec.Nets, original.Spec.Nets)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !match || !equality.Semantic.DeepEqual(networkSet.ObjectMeta, original.ObjectMeta) {
+			controllerDomainSetLog.Info("Update networkset", "request", req.NamespacedName)
+			err = r.Update(ctx, networkSet)
+			if err != nil {
+				controllerDomainSetLog.Error(err, "cannot update NetworkSet", "request", req.NamespacedName)
+				monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+				r.schedule.forget(key)
+				return ctrl.Result{}, err
+			}
+			monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+		}
+	}
+
+	status := domainSet.Status.DeepCopy()
+	setDomainSetStatus(&domainSet.Status, domainSet.GetGeneration(), networkSet.Spec.Nets, resolveErr, now)
+	if !equality.Semantic.DeepEqual(status, &domainSet.Status) {
+		err = r.Status().Update(ctx, domainSet)
+		if err != nil {
+			controllerDomainSetLog.Error(err, "cannot update DomainSet status", "request", req.NamespacedName)
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// denyDomainSet removes the networkset of the domainset with the sources which are not allowed in its namespace
+// and records the denied sources in the status
+func (r *DomainSetReconciler) denyDomainSet(ctx context.Context, domainSet *v1alpha1.DomainSet, networkSet *calicov3.NetworkSet, exists bool, denied []string) error {
+	controllerDomainSetLog.Info("Domains are not allowed in the namespace", "namespace", domainSet.GetNamespace(), "name", domainSet.GetName(), "denied", denied)
+	if exists {
+		err := r.Delete(ctx, networkSet)
+		if client.IgnoreNotFound(err) != nil {
+			controllerDomainSetLog.Error(err, "cannot delete NetworkSet", "namespace", networkSet.GetNamespace(), "name", networkSet.GetName())
+			monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationDelete)
+			return err
+		}
+		monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationDelete)
+	}
+
+	status := domainSet.Status.DeepCopy()
+	setDomainSetDenied(&domainSet.Status, domainSet.GetGeneration(), domainSet.GetNamespace(), denied)
+	if equality.Semantic.DeepEqual(status, &domainSet.Status) {
+		return nil
+	}
+	err := r.Status().Update(ctx, domainSet)
+	if err != nil {
+		controllerDomainSetLog.Error(err, "cannot update DomainSet status", "namespace", domainSet.GetNamespace(), "name", domainSet.GetName())
+	}
+	return err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// Status updates of the domainset are ignored, changes of its networkset are reconciled,
+// the domainsets are reconciled again when the allowlist of their namespace is changed
+func (r *DomainSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.DomainSet{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&calicov3.NetworkSet{}).
+		Watches(&corev1.Namespace{}, enqueueNamespaceObjects(mgr.GetClient(), &v1alpha1.DomainSetList{}),
+			builder.WithPredicates(allowlistChangedPredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles(r.MaxConcurrentReconciles)}).
+		Complete(r)
+}
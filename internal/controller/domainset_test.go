@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/javdet/networksets-controller/api/v1alpha1"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetDomainSetLabels(t *testing.T) {
+	resolvers := resolver.NewRegistry()
+	resolvers.Register(resolver.DNSKey, resolver.NewDNSResolver(nil))
+	domainSet := &v1alpha1.DomainSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "github", Labels: map[string]string{
+		"team":               "a",
+		controlPlaneLabel:    controlPlaneValue,
+		parentPolicyLabel:    "policy",
+		parentPolicyUIDLabel: "uid",
+		domainSetLabel:       "other",
+		resolver.DNSKey:      "github.com",
+	}}}
+	expected := map[string]string{"team": "a", domainSetLabel: "github"}
+	if labels := getDomainSetLabels(domainSet, resolvers); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("labels are %v, expected %v", labels, expected)
+	}
+}
+
+func TestResolveDomainSetErrors(t *testing.T) {
+	resolvers := resolver.NewRegistry()
+	resolvers.Register("OK_RESOLVER", &countingResolver{})
+	resolvers.Register("FAIL_RESOLVER", &countingResolver{fail: true})
+	failing := &countingResolver{fail: true}
+	resolvers.Register("OTHER_RESOLVER", failing)
+	spec := v1alpha1.DomainSetSpec{Sources: []v1alpha1.DomainSetSource{
+		{Resolver: "FAIL_RESOLVER", Value: "a"},
+		{Resolver: "OK_RESOLVER", Value: "b"},
+		{Resolver: "OTHER_RESOLVER", Value: "c"},
+	}}
+	_, _, err := resolveDomainSet(context.Background(), resolvers, spec, resolver.DualStack)
+	if err == nil {
+		t.Fatalf("failed sources are not reported")
+	}
+	if failing.resolves.Load() != 1 {
+		t.Errorf("resolving stops at the first failed source")
+	}
+	status := &v1alpha1.DomainSetStatus{}
+	setDomainSetStatus(status, 1, nil, err, time.Now())
+	message := status.Conditions[0].Message
+	for _, source := range []string{"FAIL_RESOLVER a", "OTHER_RESOLVER c"} {
+		if !strings.Contains(message, source) {
+			t.Errorf("condition message %q does not report %s", message, source)
+		}
+	}
+}
+
+func TestResolveDomainSetDNSKey(t *testing.T) {
+	resolvers := resolver.NewRegistry()
+	dns := &countingResolver{}
+	resolvers.Register("DNS", dns)
+	resolvers.SetDNSKey("DNS")
+	spec := v1alpha1.DomainSetSpec{Domains: []string{"example.com"}}
+	if _, _, err := resolveDomainSet(context.Background(), resolvers, spec, resolver.DualStack); err != nil {
+		t.Fatalf("cannot resolve domains by the configured DNS label: %v", err)
+	}
+	if dns.resolves.Load() != 1 {
+		t.Errorf("domains are resolved %d times by the configured DNS label, expected once", dns.resolves.Load())
+	}
+}
+
+func TestSetDomainSetStatusRefreshTime(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := created.Add(time.Hour)
+	for _, tc := range []struct {
+		name     string
+		networks []string
+		err      error
+		refresh  time.Time
+	}{
+		{name: "same networks", networks: []string{"192.0.2.1/32"}, refresh: created},
+		{name: "changed networks", networks: []string{"192.0.2.2/32"}, refresh: later},
+		{name: "failed resolve", networks: []string{"192.0.2.1/32"}, err: errors.New("SERVFAIL"), refresh: created},
+	} {
+		status := &v1alpha1.DomainSetStatus{}
+		setDomainSetStatus(status, 1, []string{"192.0.2.1/32"}, nil, created)
+		setDomainSetStatus(status, 1, tc.networks, tc.err, later)
+		if !status.LastRefreshTime.Time.Equal(tc.refresh) {
+			t.Errorf("%s: refresh time is %v, expected %v", tc.name, status.LastRefreshTime.Time, tc.refresh)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+var (
+	// netsHashAnnotation is the hash of the networks written by the controller,
+	// the networks edited manually do not match it and are reverted
+	netsHashAnnotation = annotationPrefix + "nets-hash"
+	// manualOverrideAnnotation set to true keeps the networks of the networkset edited manually,
+	// the controller still manages the labels and removes the networkset with its policy
+	manualOverrideAnnotation = annotationPrefix + "manual-override"
+)
+
+// netsHash returns the hash of the networks, the order and duplicates are ignored
+func netsHash(nets []string) string {
+	prefixes, err := canonicalPrefixes(nets)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.New()
+	for _, prefix := range prefixes {
+		hash.Write([]byte(prefix.String()))
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// setNetsHash records the hash of the networks written by the controller
+func setNetsHash(annotations map[string]string, nets []string) {
+	annotations[netsHashAnnotation] = netsHash(nets)
+}
+
+// netsDrifted reports whether the networks were edited after the controller wrote them.
+// Networksets written before the hash was introduced are not drifted
+func netsDrifted(annotations map[string]string, nets []string) bool {
+	hash, ok := annotations[netsHashAnnotation]
+	return ok && hash != netsHash(nets)
+}
+
+// isManualOverride reports whether the networks of the networkset are managed manually
+func isManualOverride(annotations map[string]string) bool {
+	override, err := strconv.ParseBool(annotations[manualOverrideAnnotation])
+	return err == nil && override
+}
+
+// managedNets returns the networks of the networkset and records their hash in the annotations.
+// The networks edited manually are kept with the manual override, otherwise they are not accumulated
+// and are replaced by the resolved networks. It also returns the time the next accumulated network expires
+func managedNets(annotations map[string]string, current []string, resolved []string, resolveErr error, family resolver.AddressFamily, failurePolicy FailurePolicy, now time.Time) ([]string, time.Time) {
+	if isManualOverride(annotations) {
+		return current, time.Time{}
+	}
+	drifted := netsDrifted(annotations, current)
+	var expires time.Time
+	if resolveErr == nil {
+		accumulated := current
+		if drifted {
+			accumulated = nil
+		}
+		resolved, expires = accumulateNetworks(annotations, accumulated, resolved, family, now)
+	}
+	nets := failurePolicy.networks(annotations, current, resolved, resolveErr, now)
+	// the networks edited manually and kept on failure are reverted when the domain is resolved
+	if resolveErr == nil || !drifted {
+		setNetsHash(annotations, nets)
+	}
+	return nets, expires
+}
+
+// ownedSetPredicate passes the deleted networksets and the networksets with changed labels to the policy reconciler,
+// the networks edited manually are reverted by the networkset reconciler
+var ownedSetPredicate = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !maps.Equal(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+	},
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/javdet/networksets-controller/monitoring"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+var dryRunLog = ctrl.Log.WithName("dry-run")
+
+// dryRunClient reads from the wrapped client and skips all writes. Every skipped write is logged
+// with the merge patch against the current object and the changed networks, and is counted in the dry-run metrics
+type dryRunClient struct {
+	client.Client
+}
+
+// NewDryRunClient wraps the client so the reconcilers compute their changes without applying them
+func NewDryRunClient(c client.Client) client.Client {
+	return &dryRunClient{Client: c}
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.skip(monitoring.OperationCreate, obj, nil, "")
+	return nil
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		// the object is created by the skipped create
+		c.skip(monitoring.OperationUpdate, obj, nil, "")
+		return client.IgnoreNotFound(err)
+	}
+	patch, err := client.MergeFrom(current).Data(obj)
+	if err != nil {
+		return err
+	}
+	c.skip(monitoring.OperationUpdate, obj, current, string(patch))
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.skip("patch", obj, nil, string(data))
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.skip(monitoring.OperationDelete, nil, obj, "")
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.skip("deleteallof", nil, obj, "")
+	return nil
+}
+
+func (c *dryRunClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *dryRunClient) SubResource(subResource string) client.SubResourceClient {
+	return &dryRunSubResourceClient{
+		SubResourceClient: c.Client.SubResource(subResource),
+		client:            c,
+		subResource:       subResource,
+	}
+}
+
+// skip logs and counts the skipped write of the object, newObj is nil for deletes and oldObj is the current object
+func (c *dryRunClient) skip(operation string, newObj client.Object, oldObj client.Object, patch string) {
+	obj := newObj
+	if obj == nil {
+		obj = oldObj
+	}
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	kind = strings.ToLower(kind)
+
+	added, removed := networksDiff(objectNets(oldObj), objectNets(newObj))
+	values := []interface{}{"kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName()}
+	if len(added) > 0 || len(removed) > 0 {
+		values = append(values, "added", added, "removed", removed)
+	}
+	if patch != "" {
+		values = append(values, "patch", patch)
+	}
+	dryRunLog.Info("Skip "+operation, values...)
+	monitoring.DryRunOperation(kind, operation, len(added), len(removed))
+}
+
+// dryRunSubResourceClient skips writes of the subresources like status
+type dryRunSubResourceClient struct {
+	client.SubResourceClient
+	client      *dryRunClient
+	subResource string
+}
+
+func (c *dryRunSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	c.client.skip(monitoring.OperationCreate+" "+c.subResource, obj, nil, "")
+	return nil
+}
+
+func (c *dryRunSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	c.client.skip(monitoring.OperationUpdate+" "+c.subResource, obj, nil, "")
+	return nil
+}
+
+func (c *dryRunSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.client.skip("patch "+c.subResource, obj, nil, string(data))
+	return nil
+}
+
+// dryRunRecorder logs the events instead of recording them
+type dryRunRecorder struct{}
+
+// NewDryRunRecorder returns the recorder of the dry-run mode, the events are logged only
+func NewDryRunRecorder() record.EventRecorder {
+	return dryRunRecorder{}
+}
+
+func (dryRunRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	values := []interface{}{"type", eventtype, "reason", reason, "message", message}
+	if obj, ok := object.(client.Object); ok {
+		values = append(values, "namespace", obj.GetNamespace(), "name", obj.GetName())
+	}
+	dryRunLog.Info("Skip event", values...)
+}
+
+func (r dryRunRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r dryRunRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// objectNets returns the networks of the networkset or globalnetworkset
+func objectNets(obj client.Object) []string {
+	switch set := obj.(type) {
+	case *calicov3.NetworkSet:
+		return set.Spec.Nets
+	case *calicov3.GlobalNetworkSet:
+		return set.Spec.Nets
+	}
+	return nil
+}
+
+// networksDiff returns the networks added to and removed from the old networks,
+// the invalid networks are compared as strings
+func networksDiff(oldNets, newNets []string) ([]string, []string) {
+	oldPrefixes, err := canonicalPrefixes(oldNets)
+	if err != nil {
+		return diffStrings(oldNets, newNets)
+	}
+	newPrefixes, err := canonicalPrefixes(newNets)
+	if err != nil {
+		return diffStrings(oldNets, newNets)
+	}
+	removed := map[netip.Prefix]bool{}
+	for _, prefix := range oldPrefixes {
+		removed[prefix] = true
+	}
+	var added []string
+	for _, prefix := range newPrefixes {
+		if removed[prefix] {
+			delete(removed, prefix)
+		} else {
+			added = append(added, prefix.String())
+		}
+	}
+	var removedNets []string
+	for _, prefix := range oldPrefixes {
+		if removed[prefix] {
+			removedNets = append(removedNets, prefix.String())
+		}
+	}
+	return added, removedNets
+}
+
+func diffStrings(oldValues, newValues []string) ([]string, []string) {
+	old := map[string]bool{}
+	for _, value := range oldValues {
+		old[value] = true
+	}
+	var added []string
+	for _, value := range newValues {
+		if old[value] {
+			delete(old, value)
+		} else {
+			added = append(added, value)
+		}
+	}
+	var removed []string
+	for _, value := range oldValues {
+		if old[value] {
+			removed = append(removed, value)
+			delete(old, value)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// resolveFailedSinceAnnotation is the time of the first failed resolve in RFC3339 format
+	resolveFailedSinceAnnotation = annotationPrefix + "resolve-failed-since"
+	// lastErrorAnnotation is the error of the last failed resolve
+	lastErrorAnnotation = annotationPrefix + "last-error"
+)
+
+// maxErrorLength limits the length of the error annotation
+const maxErrorLength = 1024
+
+// FailureAction is applied to the networkset when the domain is not resolved longer than the grace period
+type FailureAction string
+
+const (
+	// FailureKeep keeps the last known good networks until the domain is resolved again
+	FailureKeep FailureAction = "Keep"
+	// FailureEmpty removes the networks of the networkset
+	FailureEmpty FailureAction = "Empty"
+)
+
+// ParseFailureAction parses Keep or Empty (case insensitive)
+func ParseFailureAction(value string) (FailureAction, error) {
+	for _, action := range []FailureAction{FailureKeep, FailureEmpty} {
+		if strings.EqualFold(value, string(action)) {
+			return action, nil
+		}
+	}
+	return "", fmt.Errorf("unknown failure action %q, expected %s or %s", value, FailureKeep, FailureEmpty)
+}
+
+// FailurePolicy defines networks of the networkset when resolving fails
+type FailurePolicy struct {
+	// Action is applied when the grace period is over
+	Action FailureAction
+	// GracePeriod is the time the last known good networks are kept
+	GracePeriod time.Duration
+}
+
+// networks records the result of the resolve in the networkset annotations and returns the networks of the networkset.
+// On success the failure state is removed and the resolved networks are returned,
+// on failure the current networks are kept until the grace period is over
+func (p FailurePolicy) networks(annotations map[string]string, current []string, resolved []string, resolveErr error, now time.Time) []string {
+	if resolveErr == nil {
+		delete(annotations, resolveFailedSinceAnnotation)
+		delete(annotations, lastErrorAnnotation)
+		return resolved
+	}
+
+	since, err := time.Parse(time.RFC3339, annotations[resolveFailedSinceAnnotation])
+	if err != nil {
+		since = now
+		annotations[resolveFailedSinceAnnotation] = now.UTC().Format(time.RFC3339)
+	}
+	message := resolveErr.Error()
+	if len(message) > maxErrorLength {
+		message = message[:maxErrorLength]
+	}
+	annotations[lastErrorAnnotation] = message
+
+	if p.Action == FailureEmpty && now.Sub(since) >= p.GracePeriod {
+		delete(annotations, lastSeenAnnotation)
+		return []string{}
+	}
+	return current
+}
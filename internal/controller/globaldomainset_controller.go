@@ -0,0 +1,203 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/api/v1alpha1"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"github.com/javdet/networksets-controller/monitoring"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// GlobalDomainSetReconciler resolves GlobalDomainSet to the GlobalNetworkSet with the same name
+type GlobalDomainSetReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Resolvers *resolver.Registry
+	// Settings are loaded at the start of every reconcile, they are replaced when the configuration is reloaded
+	Settings *SettingsStore
+	// MaxConcurrentReconciles is the number of concurrent reconciles, 2 if not set
+	MaxConcurrentReconciles int
+
+	schedule refreshSchedule
+}
+
+var controllerGlobalDomainSetLog = ctrl.Log.WithName("controller").WithName("GlobalDomainSet")
+
+//+kubebuilder:rbac:groups=networksets.javdet.io,resources=globaldomainsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networksets.javdet.io,resources=globaldomainsets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networksets.javdet.io,resources=globaldomainsets/finalizers,verbs=update
+
+// Reconcile resolves domains and sources of the globaldomainset and writes the networks to its globalnetworkset.
+// The globaldomainset is requeued when the records TTL expires, failed resolves are retried with backoff
+func (r *GlobalDomainSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	controllerGlobalDomainSetLog.Info("start reconcile", "request", req.NamespacedName)
+	settings := r.Settings.Load()
+	key := req.NamespacedName.Name
+
+	globalDomainSet := &v1alpha1.GlobalDomainSet{}
+	err := r.Get(ctx, req.NamespacedName, globalDomainSet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the globalnetworkset is deleted by the garbage collector
+			r.schedule.forget(key)
+			return ctrl.Result{}, nil
+		}
+		controllerGlobalDomainSetLog.Error(err, "cannot get object GlobalDomainSet")
+		return ctrl.Result{}, err
+	}
+
+	globalNetworkSet := &calicov3.GlobalNetworkSet{}
+	err = r.Get(ctx, req.NamespacedName, globalNetworkSet)
+	if client.IgnoreNotFound(err) != nil {
+		controllerGlobalDomainSetLog.Error(err, "cannot get object GlobalNetworkSet")
+		return ctrl.Result{}, err
+	}
+	exists := err == nil
+	now := time.Now()
+	if exists && !metav1.IsControlledBy(globalNetworkSet, globalDomainSet) {
+		err = fmt.Errorf("globalnetworkset %s is not managed by the globaldomainset", req.NamespacedName.Name)
+		controllerGlobalDomainSetLog.Error(err, "cannot write GlobalNetworkSet")
+		setDomainSetFailed(&globalDomainSet.Status, globalDomainSet.GetGeneration(), err)
+		retry := r.schedule.failed(key, now, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		return ctrl.Result{RequeueAfter: retry}, r.Status().Update(ctx, globalDomainSet)
+	}
+
+	// changes of the spec, the deleted globalnetworkset and the globalnetworkset edited manually are resolved at once,
+	// other events wait for the scheduled time
+	drifted := netsDrifted(globalNetworkSet.GetAnnotations(), globalNetworkSet.Spec.Nets) || !maps.Equal(globalNetworkSet.GetLabels(), getDomainSetLabels(globalDomainSet, r.Resolvers))
+	if exists && globalDomainSet.Status.ObservedGeneration == globalDomainSet.GetGeneration() && !drifted {
+		if remaining := r.schedule.remaining(key, now); remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	family := getDomainSetFamily(globalDomainSet.Spec, settings.AddressFamily)
+	resolved, ttl, resolveErr := resolveDomainSet(ctx, r.Resolvers, globalDomainSet.Spec, family)
+	var interval time.Duration
+	if resolveErr != nil {
+		interval = r.schedule.failed(key, now, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		controllerGlobalDomainSetLog.Error(resolveErr, "cannot resolve globaldomainset, retry later", "request", req.NamespacedName, "retry", interval)
+	} else {
+		if ttl == 0 {
+			ttl = settings.RefreshInterval
+			if globalDomainSet.Spec.RefreshInterval != nil {
+				ttl = globalDomainSet.Spec.RefreshInterval.Duration
+			}
+		}
+		interval = refreshInterval(ttl, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+	}
+
+	if !exists {
+		globalNetworkSet = &calicov3.GlobalNetworkSet{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "GlobalNetworkSet",
+				APIVersion: "projectcalico.org/v3",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: globalDomainSet.GetName(),
+			},
+		}
+	}
+	original := globalNetworkSet.DeepCopy()
+	annotations := updateDomainSetAnnotations(maps.Clone(globalNetworkSet.GetAnnotations()), globalDomainSet.Spec)
+	networks, expires := managedNets(annotations, original.Spec.Nets, resolved, resolveErr, family, settings.FailurePolicy, now)
+	if resolveErr == nil {
+		// accumulated networks are removed when they expire
+		if !expires.IsZero() && expires.Sub(now) < interval {
+			interval = refreshInterval(expires.Sub(now), settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		}
+		r.schedule.succeeded(key, now.Add(interval))
+	}
+	globalNetworkSet.SetLabels(getDomainSetLabels(globalDomainSet, r.Resolvers))
+	globalNetworkSet.SetAnnotations(annotations)
+	globalNetworkSet.SetOwnerReferences(updateControllerRef(globalNetworkSet.GetOwnerReferences(),
+		metav1.NewControllerRef(globalDomainSet, v1alpha1.GroupVersion.WithKind("GlobalDomainSet"))))
+	globalNetworkSet.Spec.Nets = networks
+
+	if !exists {
+		controllerGlobalDomainSetLog.Info("Create globalnetworkset", "request", req.NamespacedName)
+		err = r.Create(ctx, globalNetworkSet)
+		if err != nil {
+			controllerGlobalDomainSetLog.Error(err, "cannot create GlobalNetworkSet", "request", req.NamespacedName)
+			monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationCreate)
+			r.schedule.forget(key)
+			setDomainSetFailed(&globalDomainSet.Status, globalDomainSet.GetGeneration(), err)
+			if statusErr := r.Status().Update(ctx, globalDomainSet); statusErr != nil {
+				controllerGlobalDomainSetLog.Error(statusErr, "cannot update GlobalDomainSet status", "request", req.NamespacedName)
+			}
+			return ctrl.Result{}, err
+		}
+		monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationCreate)
+	} else {
+		match, err := arraysMatch(globalNetworkSet.Spec.Nets, original.Spec.Nets)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !match || !equality.Semantic.DeepEqual(globalNetworkSet.ObjectMeta, original.ObjectMeta) {
+			controllerGlobalDomainSetLog.Info("Update globalnetworkset", "request", req.NamespacedName)
+			err = r.Update(ctx, globalNetworkSet)
+			if err != nil {
+				controllerGlobalDomainSetLog.Error(err, "cannot update GlobalNetworkSet", "request", req.NamespacedName)
+				monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationUpdate)
+				r.schedule.forget(key)
+				return ctrl.Result{}, err
+			}
+			monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationUpdate)
+		}
+	}
+
+	status := globalDomainSet.Status.DeepCopy()
+	setDomainSetStatus(&globalDomainSet.Status, globalDomainSet.GetGeneration(), globalNetworkSet.Spec.Nets, resolveErr, now)
+	if !equality.Semantic.DeepEqual(status, &globalDomainSet.Status) {
+		err = r.Status().Update(ctx, globalDomainSet)
+		if err != nil {
+			controllerGlobalDomainSetLog.Error(err, "cannot update GlobalDomainSet status", "request", req.NamespacedName)
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// Status updates of the globaldomainset are ignored, changes of its globalnetworkset are reconciled
+func (r *GlobalDomainSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.GlobalDomainSet{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&calicov3.GlobalNetworkSet{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles(r.MaxConcurrentReconciles)}).
+		Complete(r)
+}
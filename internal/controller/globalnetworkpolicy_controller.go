@@ -18,13 +18,19 @@ package controller
 
 import (
 	"context"
-	"fmt"
+	"slices"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/internal/resolver"
 	"github.com/javdet/networksets-controller/monitoring"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -32,11 +38,18 @@ import (
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 )
 
-// NetworkPolicyReconciler reconciles a Networkset object
+// GlobalNetworkPolicyReconciler creates and updates the globalnetworksets of the resolver labels of GlobalNetworkPolicy
 type GlobalNetworkPolicyReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Resolvers *resolver.Registry
+	// Settings are loaded at the start of every reconcile, they are replaced when the configuration is reloaded
+	Settings *SettingsStore
+	// MaxConcurrentReconciles is the number of concurrent reconciles, 2 if not set
+	MaxConcurrentReconciles int
+	// Recorder records events of the networksets on the policy
+	Recorder record.EventRecorder
 }
 
 var controllerGlobalNetworksetsLog = ctrl.Log.WithName("controller").WithName("GlobalNetworkpolicy")
@@ -44,41 +57,33 @@ var controllerGlobalNetworksetsLog = ctrl.Log.WithName("controller").WithName("G
 //+kubebuilder:rbac:groups=crd.projectcalico.org,resources=globalnetworkpolicies,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=crd.projectcalico.org,resources=globalnetworkpolicies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=crd.projectcalico.org,resources=globalnetworkpolicies/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Memcached object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
+// Reconcile creates and updates the globalnetworksets of the resolver terms of the globalnetworkpolicy,
+// the globalnetworksets of the terms removed from the rules are deleted
 func (r *GlobalNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
 	controllerGlobalNetworksetsLog.Info("start reconcile", "request", req.NamespacedName)
+	settings := r.Settings.Load()
 
 	instance := &calicov3.GlobalNetworkPolicy{}
 	globalNetworkSetList := &calicov3.GlobalNetworkSetList{}
 
-	opts := []client.ListOption{
-		client.MatchingLabels{
-			"control-plane":        "networksets-operator",
-			"parent-networkPolicy": req.NamespacedName.Name,
-		},
-	}
-	err := r.List(ctx, globalNetworkSetList, opts...)
+	err := r.List(ctx, globalNetworkSetList)
 	if err != nil {
 		controllerGlobalNetworksetsLog.Error(err, "cannot get object GlobalNetworkSet list")
 		return ctrl.Result{}, err
 	}
 	err = r.Get(ctx, req.NamespacedName, instance)
+	globalNetworkSetList.Items = slices.DeleteFunc(globalNetworkSetList.Items, func(globalNetworkSet calicov3.GlobalNetworkSet) bool {
+		return !isManagedBy(&globalNetworkSet, req.NamespacedName.Name, instance.GetUID())
+	})
 
 	if err != nil {
 		controllerGlobalNetworksetsLog.Error(err, "cannot get object GlobalNetworkPolicy")
 		if apierrors.IsNotFound(err) {
-			deleteGlobalNetworkSetList := getGlobalNetworkSetList(req.NamespacedName.Name, req.NamespacedName.Namespace, globalNetworkSetList)
-			for _, globalNetworkSet := range deleteGlobalNetworkSetList.Items {
+			// the list contains globalnetworksets labeled by the name of the deleted policy only
+			for _, globalNetworkSet := range globalNetworkSetList.Items {
 				if globalNetworkSet.GetName() != "" {
 					controllerGlobalNetworksetsLog.Info("Remove globalnetworkset", "name", globalNetworkSet.GetName())
 					err = r.Delete(
@@ -87,10 +92,10 @@ func (r *GlobalNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 					)
 					if err != nil {
 						controllerGlobalNetworksetsLog.Error(err, "cannot delete GlobalNetworkSet")
-						monitoring.NetworksetControllerGlobalNetworksetDeletionFailed.Inc()
+						monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationDelete)
 						return ctrl.Result{}, err
 					}
-					monitoring.NetworksetControllerGlobalNetworksetDeleted.Inc()
+					monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationDelete)
 				}
 			}
 			return ctrl.Result{}, nil
@@ -98,43 +103,61 @@ func (r *GlobalNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 		return ctrl.Result{}, err
 	}
 
-	var domain string
-	for ruleNumber, rule := range instance.Spec.Egress {
-		matches := destinationSelector.FindStringSubmatch(rule.Destination.Selector)
-		if len(matches) > 1 {
-			domain = matches[1]
-			controllerGlobalNetworksetsLog.Info("Found domain", "request", req.NamespacedName, "domain", domain)
-			ipAddress, err := resolveDomain(domain)
+	var label, domain string
+	terms := resolverTerms(controllerGlobalNetworksetsLog, r.Resolvers, ruleSelectors(instance.Spec.Ingress, instance.Spec.Egress))
+	err = r.pruneGlobalNetworkSets(ctx, instance, globalNetworkSetList, terms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, term := range terms {
+		label, domain = term.Key, term.Value
+		controllerGlobalNetworksetsLog.Info("Found domain", "request", req.NamespacedName, "label", label, "domain", domain)
+		// the networkset is created or updated even when the domain is not resolved, the networkset controller retries the resolve
+		prefixes, resolveErr := r.Resolvers.Resolve(ctx, label, domain)
+		if resolveErr != nil {
+			controllerGlobalNetworksetsLog.Error(resolveErr, "cannot resolve domain", "request", req.NamespacedName, "label", label, "domain", domain)
+			r.Recorder.Eventf(instance, corev1.EventTypeWarning, reasonResolveFailed, "cannot resolve %s == '%s': %s", label, domain, resolveErr)
+		}
+		family := getAddressFamily(instance.GetAnnotations(), settings.AddressFamily)
+		ipAddress := resolver.FormatPrefixes(family.Filter(prefixes))
+		source := r.Resolvers.Source(label, domain)
+		now := time.Now()
+
+		networkSet := getGlobalNetworkSet(instance, label, domain, globalNetworkSetList)
+		if networkSet.GetName() != "" {
+			original := networkSet.DeepCopy()
+			networkSet = updateGlobalNetworkset(instance, networkSet, label, domain, ipAddress)
+			networkSet.Spec.Nets, _ = managedNets(networkSet.GetAnnotations(), original.Spec.Nets, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			match, err := arraysMatch(networkSet.Spec.Nets, original.Spec.Nets)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-
-			networkSet := getGlobalNetworkSet(req.NamespacedName.Name, req.NamespacedName.Namespace, domain, globalNetworkSetList)
-			if networkSet.GetName() != "" {
-				controllerGlobalNetworksetsLog.Info("Update existing networkset", "request", req.NamespacedName, "name", fmt.Sprint(req.NamespacedName.Name, "-", ruleNumber))
-				err = r.Update(
-					ctx,
-					updateGlobalNetworkset(instance, networkSet, domain, ipAddress),
-				)
-				if err != nil {
-					controllerGlobalNetworksetsLog.Error(err, "cannot update NetworkSet", "name", fmt.Sprint(req.NamespacedName.Name, "-", ruleNumber))
-					monitoring.NetworksetControllerGlobalNetworksetUpdateFailed.Inc()
-					return ctrl.Result{}, err
-				}
-				monitoring.NetworksetControllerGlobalNetworksetUpdated.Inc()
-			} else {
-				controllerGlobalNetworksetsLog.Info("Create networkset", "request", req.NamespacedName, "name", fmt.Sprint(req.NamespacedName.Name, "-", ruleNumber))
-				err = r.Create(
-					ctx,
-					createGlobalNetworkset(instance, ruleNumber, domain, ipAddress),
-				)
-				if err != nil {
-					controllerGlobalNetworksetsLog.Error(err, "cannot create NetworkSet", "name", fmt.Sprint(req.NamespacedName.Name, "-", ruleNumber))
-					monitoring.NetworksetControllerGlobalNetworksetCreationFailed.Inc()
-					return ctrl.Result{}, err
-				}
-				monitoring.NetworksetControllerGlobalNetworksetCreated.Inc()
+			setResolveStatus(networkSet.GetAnnotations(), source, len(ipAddress), resolveErr, !match || resolveFailed(original.GetAnnotations()), settings.RefreshInterval, now)
+			// unchanged networksets are not updated, the update triggers reconcile of the networkset
+			if match && equality.Semantic.DeepEqual(networkSet.ObjectMeta, original.ObjectMeta) {
+				continue
+			}
+			controllerGlobalNetworksetsLog.Info("Update existing globalnetworkset", "request", req.NamespacedName, "name", networkSet.GetName())
+			err = r.Update(ctx, networkSet)
+			if err != nil {
+				controllerGlobalNetworksetsLog.Error(err, "cannot update GlobalNetworkSet", "name", networkSet.GetName())
+				monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationUpdate)
+				return ctrl.Result{}, err
+			}
+			monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationUpdate)
+		} else {
+			networkSet = newGlobalNetworkset(instance, label, domain, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			setResolveStatus(networkSet.GetAnnotations(), source, len(ipAddress), resolveErr, true, settings.RefreshInterval, now)
+			controllerGlobalNetworksetsLog.Info("Create globalnetworkset", "request", req.NamespacedName, "name", networkSet.GetName())
+			err = r.Create(ctx, networkSet)
+			if err != nil {
+				controllerGlobalNetworksetsLog.Error(err, "cannot create GlobalNetworkSet", "name", networkSet.GetName())
+				monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationCreate)
+				return ctrl.Result{}, err
 			}
+			monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationCreate)
+			r.Recorder.Eventf(instance, corev1.EventTypeNormal, reasonNetworkSetCreated, "GlobalNetworkSet %s is created for %s == '%s'", networkSet.GetName(), label, domain)
 		}
 	}
 
@@ -142,9 +165,11 @@ func (r *GlobalNetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// Deleted globalnetworksets and globalnetworksets with edited labels are restored
 func (r *GlobalNetworkPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&calicov3.GlobalNetworkPolicy{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 2}).
+		Owns(&calicov3.GlobalNetworkSet{}, builder.WithPredicates(ownedSetPredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles(r.MaxConcurrentReconciles)}).
 		Complete(r)
 }
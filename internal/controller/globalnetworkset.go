@@ -1,23 +1,31 @@
 package controller
 
 import (
-	"fmt"
-	"strings"
+	"context"
+	"time"
 
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"github.com/javdet/networksets-controller/internal/selector"
+	"github.com/javdet/networksets-controller/monitoring"
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func createGlobalNetworkset(instance *calicov3.GlobalNetworkPolicy, ruleNumber int, domain string, ipAddress []string) *calicov3.GlobalNetworkSet {
+func createGlobalNetworkset(instance *calicov3.GlobalNetworkPolicy, label string, domain string, ipAddress []string) *calicov3.GlobalNetworkSet {
 	return &calicov3.GlobalNetworkSet{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "GlobalNetworkSet",
 			APIVersion: "projectcalico.org/v3",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        fmt.Sprint(instance.GetName(), "-", transformDomain(domain)),
-			Labels:      getLabels(instance.GetName(), domain),
-			Annotations: getAnnotations(),
+			Name:        networkSetName(instance.GetName(), label, domain),
+			Labels:      getLabels(instance, label, domain),
+			Annotations: getAnnotations(instance.GetAnnotations()),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(instance, calicov3.SchemeGroupVersion.WithKind(calicov3.KindGlobalNetworkPolicy)),
+			},
 		},
 		Spec: calicov3.GlobalNetworkSetSpec{
 			Nets: ipAddress,
@@ -25,17 +33,26 @@ func createGlobalNetworkset(instance *calicov3.GlobalNetworkPolicy, ruleNumber i
 	}
 }
 
-func updateGlobalNetworkset(instance *calicov3.GlobalNetworkPolicy, globalNetworkSet *calicov3.GlobalNetworkSet, domain string, ipAddress []string) *calicov3.GlobalNetworkSet {
-	globalNetworkSet.SetLabels(getLabels(instance.GetName(), domain))
+// newGlobalNetworkset builds the globalnetworkset of the new policy, the networks are accumulated
+// and the failure of the resolve is recorded in the annotations
+func newGlobalNetworkset(instance *calicov3.GlobalNetworkPolicy, label string, domain string, ipAddress []string, resolveErr error, family resolver.AddressFamily, failurePolicy FailurePolicy, now time.Time) *calicov3.GlobalNetworkSet {
+	networkSet := createGlobalNetworkset(instance, label, domain, ipAddress)
+	networkSet.Spec.Nets, _ = managedNets(networkSet.GetAnnotations(), nil, ipAddress, resolveErr, family, failurePolicy, now)
+	return networkSet
+}
+
+func updateGlobalNetworkset(instance *calicov3.GlobalNetworkPolicy, globalNetworkSet *calicov3.GlobalNetworkSet, label string, domain string, ipAddress []string) *calicov3.GlobalNetworkSet {
+	adoptNetworkset(globalNetworkSet, instance, calicov3.KindGlobalNetworkPolicy, label, domain)
+	globalNetworkSet.SetAnnotations(updateAnnotations(globalNetworkSet.GetAnnotations(), instance.GetAnnotations()))
 	globalNetworkSet.Spec.Nets = ipAddress
 	return globalNetworkSet
 }
 
-func getGlobalNetworkSet(policyName string, PolicyNamespace string, domain string, globalNetworkSetList *calicov3.GlobalNetworkSetList) *calicov3.GlobalNetworkSet {
+func getGlobalNetworkSet(instance *calicov3.GlobalNetworkPolicy, label string, domain string, globalNetworkSetList *calicov3.GlobalNetworkSetList) *calicov3.GlobalNetworkSet {
 	result := &calicov3.GlobalNetworkSet{}
 	for _, gloablNetworkSet := range globalNetworkSetList.Items {
-		if strings.Contains(gloablNetworkSet.GetName(), policyName) {
-			if gloablNetworkSet.GetLabels()[selectorName] == domain {
+		if isOwnedBy(gloablNetworkSet.GetLabels(), instance) {
+			if gloablNetworkSet.GetLabels()[label] == domain {
 				result = &gloablNetworkSet
 				break
 			}
@@ -45,13 +62,25 @@ func getGlobalNetworkSet(policyName string, PolicyNamespace string, domain strin
 	return result
 }
 
-func getGlobalNetworkSetList(policyName string, PolicyNamespace string, globalNetworkSetList *calicov3.GlobalNetworkSetList) *calicov3.GlobalNetworkSetList {
-	result := &calicov3.GlobalNetworkSetList{}
-	for _, gloablNetworkSet := range globalNetworkSetList.Items {
-		if strings.Contains(gloablNetworkSet.GetName(), policyName) {
-			result.Items = append(result.Items, gloablNetworkSet)
+// pruneGlobalNetworkSets deletes globalnetworksets of the policy which are not referenced by the rules anymore
+// and globalnetworksets left by the deleted policy with the same name
+func (r *GlobalNetworkPolicyReconciler) pruneGlobalNetworkSets(ctx context.Context, instance *calicov3.GlobalNetworkPolicy, globalNetworkSetList *calicov3.GlobalNetworkSetList, terms []selector.Term) error {
+	for _, globalNetworkSet := range globalNetworkSetList.Items {
+		if isOwnedBy(globalNetworkSet.GetLabels(), instance) && isDesiredTerm(r.Resolvers, globalNetworkSet.GetLabels(), terms) {
+			continue
+		}
+		controllerGlobalNetworksetsLog.Info("Remove unused globalnetworkset", "name", globalNetworkSet.GetName())
+		err := r.Delete(ctx, &globalNetworkSet)
+		if client.IgnoreNotFound(err) != nil {
+			controllerGlobalNetworksetsLog.Error(err, "cannot delete GlobalNetworkSet", "name", globalNetworkSet.GetName())
+			monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationDelete)
+			return err
+		}
+		monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationDelete)
+		if isOwnedBy(globalNetworkSet.GetLabels(), instance) {
+			r.Recorder.Eventf(instance, corev1.EventTypeNormal, reasonNetworkSetRemoved, "GlobalNetworkSet %s is removed, its domain is not referenced by the rules", globalNetworkSet.GetName())
 		}
 	}
 
-	return result
+	return nil
 }
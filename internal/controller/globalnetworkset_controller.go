@@ -18,94 +18,157 @@ package controller
 
 import (
 	"context"
-	"fmt"
+	"maps"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/internal/resolver"
 	"github.com/javdet/networksets-controller/monitoring"
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// NetworkPolicyReconciler reconciles a Networkset object
+// GlobalNetworkSetReconciler resolves the domain of the managed GlobalNetworkSet
 type GlobalNetworkSetReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Resolvers *resolver.Registry
+	// Settings are loaded at the start of every reconcile, they are replaced when the configuration is reloaded
+	Settings *SettingsStore
+	// MaxConcurrentReconciles is the number of concurrent reconciles, 2 if not set
+	MaxConcurrentReconciles int
+	// Recorder records failed and recovered resolves on the globalnetworkset
+	Recorder record.EventRecorder
+
+	schedule refreshSchedule
 }
 
 var controllerGlobalNetworksetLog = ctrl.Log.WithName("controller").WithName("GlobalNetworksets")
 
+// Reconcile resolves the domain of the managed globalnetworkset and updates its networks when they change.
+// The globalnetworkset is requeued when the records TTL expires, failed resolves are retried with backoff
 func (r *GlobalNetworkSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
-	controllerNetworksetLog.Info("start reconcile", "request", req.NamespacedName)
+	controllerGlobalNetworksetLog.Info("start reconcile", "request", req.NamespacedName)
+	settings := r.Settings.Load()
 
-	globalNetworkSetList := &calicov3.GlobalNetworkSetList{}
-	opts := []client.ListOption{
-		client.MatchingLabels{
-			"control-plane": "networksets-operator",
-		},
+	globalNetworkSet := &calicov3.GlobalNetworkSet{}
+	err := r.Get(ctx, req.NamespacedName, globalNetworkSet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			r.schedule.forget(req.NamespacedName.Name)
+			monitoring.DeleteAddresses(monitoring.KindGlobalNetworkSet, "", req.Name)
+			return ctrl.Result{}, nil
+		}
+		controllerGlobalNetworksetLog.Error(err, "cannot get object GlobalNetworkSet")
+		return ctrl.Result{}, err
 	}
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	if globalNetworkSet.GetLabels()[controlPlaneLabel] != controlPlaneValue {
+		return ctrl.Result{}, nil
+	}
+	label, domain, ok := r.Resolvers.Match(globalNetworkSet.GetLabels())
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	// the networks are written by the policy controller as well
+	monitoring.SetAddresses(monitoring.KindGlobalNetworkSet, globalNetworkSet.GetNamespace(), globalNetworkSet.GetName(), domain, len(globalNetworkSet.Spec.Nets))
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctrl.Result{}, nil
-		case <-ticker.C:
-			// Resolve the domain names and update the NetworkSet
-			err := r.List(ctx, globalNetworkSetList, opts...)
-			if err != nil {
-				controllerGlobalNetworksetLog.Error(err, "cannot get object NetworkSet list")
-				return ctrl.Result{}, err
-			}
-
-			for _, globalNetworkSet := range globalNetworkSetList.Items {
-				domain := globalNetworkSet.GetLabels()[selectorName]
-				newIpAddress, err := resolveDomain(domain)
-				if err != nil {
-					return ctrl.Result{}, err
-				}
-				oldIpAddress := globalNetworkSet.Spec.Nets
-				match, err := arraysMatch(newIpAddress, oldIpAddress)
-				if err != nil {
-					return ctrl.Result{}, err
-				}
-
-				if !match {
-					controllerGlobalNetworksetLog.Info("Update dns networkset", "Networkset", globalNetworkSet.GetName())
-					err = r.Update(
-						ctx,
-						updateGlobalNetworkset(&calicov3.GlobalNetworkPolicy{
-							ObjectMeta: metav1.ObjectMeta{
-								Name: globalNetworkSet.GetLabels()["parent-networkPolicy"],
-							},
-						},
-							&globalNetworkSet,
-							domain,
-							newIpAddress),
-					)
-					if err != nil {
-						controllerNetworksetLog.Error(err, "cannot update NetworkSet", "name", fmt.Sprint(req.NamespacedName.Name, "-", transformDomain(domain)))
-						monitoring.NetworksetControllerGlobalNetworksetUpdateFailed.Inc()
-						return ctrl.Result{}, err
-					}
-					monitoring.NetworksetControllerGlobalNetworksetUpdated.Inc()
-				}
-			}
+	// the networks edited manually are kept with the manual override
+	if isManualOverride(globalNetworkSet.GetAnnotations()) {
+		return ctrl.Result{}, nil
+	}
+
+	// updates of the globalnetworkset trigger reconcile as well, it is not resolved before the scheduled time
+	// unless the networks were edited manually or the refresh is requested by the annotation
+	now := time.Now()
+	refresh := refreshRequested(globalNetworkSet.GetAnnotations())
+	if refresh {
+		controllerGlobalNetworksetLog.Info("Refresh requested", "name", globalNetworkSet.GetName(), "domain", domain)
+		r.Resolvers.Invalidate(label, domain)
+	} else if netsDrifted(globalNetworkSet.GetAnnotations(), globalNetworkSet.Spec.Nets) {
+		controllerGlobalNetworksetLog.Info("Revert manually edited networks", "name", globalNetworkSet.GetName())
+	} else if remaining := r.schedule.remaining(req.NamespacedName.Name, now); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	prefixes, ttl, resolveErr := r.Resolvers.ResolveTTL(ctx, label, domain)
+	var interval time.Duration
+	if resolveErr != nil {
+		interval = r.schedule.failed(req.NamespacedName.Name, now, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		controllerGlobalNetworksetLog.Error(resolveErr, "cannot resolve domain, retry later", "name", globalNetworkSet.GetName(), "domain", domain, "retry", interval)
+	} else {
+		if ttl == 0 {
+			ttl = settings.RefreshInterval
+		}
+		interval = refreshInterval(ttl, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+	}
+
+	family := getAddressFamily(globalNetworkSet.GetAnnotations(), settings.AddressFamily)
+	newIpAddress := resolver.FormatPrefixes(family.Filter(prefixes))
+	oldIpAddress := globalNetworkSet.Spec.Nets
+	wasFailed := resolveFailed(globalNetworkSet.GetAnnotations())
+	annotations := maps.Clone(globalNetworkSet.GetAnnotations())
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, refreshAnnotation)
+	resolvedCount := len(newIpAddress)
+	newIpAddress, expires := managedNets(annotations, oldIpAddress, newIpAddress, resolveErr, family, settings.FailurePolicy, now)
+	if resolveErr == nil {
+		// accumulated networks are removed when they expire
+		if !expires.IsZero() && expires.Sub(now) < interval {
+			interval = refreshInterval(expires.Sub(now), settings.MinRefreshInterval, settings.MaxRefreshInterval)
 		}
+		r.schedule.succeeded(req.NamespacedName.Name, now.Add(interval))
 	}
+	match, err := arraysMatch(newIpAddress, oldIpAddress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	setResolveStatus(annotations, r.Resolvers.Source(label, domain), resolvedCount, resolveErr, !match || wasFailed, settings.RefreshInterval, now)
+
+	if !match || !maps.Equal(annotations, globalNetworkSet.GetAnnotations()) {
+		controllerGlobalNetworksetLog.Info("Update dns networkset", "Networkset", globalNetworkSet.GetName())
+		globalNetworkSet.SetAnnotations(annotations)
+		globalNetworkSet.Spec.Nets = newIpAddress
+		err = r.Update(ctx, globalNetworkSet)
+		if err != nil {
+			controllerGlobalNetworksetLog.Error(err, "cannot update GlobalNetworkSet", "name", globalNetworkSet.GetName())
+			monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationUpdate)
+			r.schedule.forget(req.NamespacedName.Name)
+			return ctrl.Result{}, err
+		}
+		monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationUpdate)
+		added, removed := networksChurn(oldIpAddress, newIpAddress)
+		monitoring.AddAddressChurn(label, domain, added, removed)
+		monitoring.SetAddresses(monitoring.KindGlobalNetworkSet, globalNetworkSet.GetNamespace(), globalNetworkSet.GetName(), domain, len(newIpAddress))
+	}
+
+	if refresh && resolveErr == nil {
+		r.Recorder.Eventf(globalNetworkSet, corev1.EventTypeNormal, reasonRefreshed, "%s == '%s' is refreshed on request, %d networks", label, domain, len(newIpAddress))
+	}
+	// events are recorded when the resolve starts failing and when it recovers
+	switch failed := resolveFailed(annotations); {
+	case failed && !wasFailed:
+		r.Recorder.Eventf(globalNetworkSet, corev1.EventTypeWarning, reasonResolveFailed, "cannot resolve %s == '%s': %s", label, domain, resolveErr)
+	case !failed && wasFailed:
+		r.Recorder.Eventf(globalNetworkSet, corev1.EventTypeNormal, reasonResolved, "%s == '%s' is resolved again", label, domain)
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
 }
 
 func (r *GlobalNetworkSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&calicov3.GlobalNetworkSet{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles(r.MaxConcurrentReconciles)}).
 		Complete(r)
 }
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ManagedSet is the networkset or globalnetworkset managed by the controller as it is recorded in its labels and annotations
+type ManagedSet struct {
+	// Object is the NetworkSet or GlobalNetworkSet
+	Object client.Object
+	// Policy and PolicyUID reference the parent policy, the uid is empty for the networksets created before the uid label
+	Policy    string
+	PolicyUID types.UID
+	// Label is the selector label resolved to the networks, like DNS_RESOLVER, and Domain is its value
+	Label  string
+	Domain string
+	Nets   []string
+	// Source, LastResolve and ResolvedCount are the result of the last successful resolve,
+	// LastChange is the time of the last successful resolve which changed the networks
+	Source        string
+	LastResolve   time.Time
+	LastChange    time.Time
+	ResolvedCount int
+	// FailedSince and LastError are set while the domain is not resolved
+	FailedSince time.Time
+	LastError   string
+	// LastSeen are the times the accumulated networks were resolved last time
+	LastSeen         map[string]time.Time
+	ManualOverride   bool
+	RefreshRequested bool
+}
+
+// ManagedLabels selects the networksets and globalnetworksets managed by the controller
+func ManagedLabels() client.MatchingLabels {
+	return client.MatchingLabels{controlPlaneLabel: controlPlaneValue}
+}
+
+// InspectSet reads the managed networkset or globalnetworkset, false is returned for other objects
+func InspectSet(obj client.Object) (ManagedSet, bool) {
+	var nets []string
+	switch set := obj.(type) {
+	case *calicov3.NetworkSet:
+		nets = set.Spec.Nets
+	case *calicov3.GlobalNetworkSet:
+		nets = set.Spec.Nets
+	default:
+		return ManagedSet{}, false
+	}
+	labels := obj.GetLabels()
+	if labels[controlPlaneLabel] != controlPlaneValue {
+		return ManagedSet{}, false
+	}
+
+	annotations := obj.GetAnnotations()
+	label, domain := setDomain(labels)
+	set := ManagedSet{
+		Object:           obj,
+		Policy:           labels[parentPolicyLabel],
+		PolicyUID:        types.UID(labels[parentPolicyUIDLabel]),
+		Label:            label,
+		Domain:           domain,
+		Nets:             nets,
+		Source:           annotations[sourceAnnotation],
+		LastError:        annotations[lastErrorAnnotation],
+		ManualOverride:   isManualOverride(annotations),
+		RefreshRequested: refreshRequested(annotations),
+	}
+	set.LastResolve, _ = time.Parse(time.RFC3339, annotations[lastResolveAnnotation])
+	set.LastChange, _ = time.Parse(time.RFC3339, annotations[lastChangeAnnotation])
+	set.FailedSince, _ = time.Parse(time.RFC3339, annotations[resolveFailedSinceAnnotation])
+	set.ResolvedCount, _ = strconv.Atoi(annotations[resolvedCountAnnotation])
+	if value, ok := annotations[lastSeenAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &set.LastSeen); err != nil {
+			set.LastSeen = nil
+		}
+	}
+	return set, true
+}
+
+// dnsLabel is the selector label resolved by DNS, it is preferred by InspectSet
+var dnsLabel = resolver.DNSKey
+
+// SetDNSLabel replaces the selector label resolved by DNS, it is the DNS label of the controller configuration
+func SetDNSLabel(label string) {
+	dnsLabel = label
+}
+
+// setDomain returns the selector label of the networkset and its value. The label is the one which is not written
+// by the controller for every networkset, the DNS label is preferred when the networkset is labeled manually
+func setDomain(labels map[string]string) (string, string) {
+	if domain, ok := labels[dnsLabel]; ok {
+		return dnsLabel, domain
+	}
+	var keys []string
+	for key := range labels {
+		switch key {
+		case controlPlaneLabel, parentPolicyLabel, parentPolicyUIDLabel, domainSetLabel:
+		default:
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", ""
+	}
+	sort.Strings(keys)
+	return keys[0], labels[keys[0]]
+}
+
+// IsOrphan reports whether the parent policy of the set is gone or was re-created with another uid,
+// the policy is nil when it is not found
+func IsOrphan(set ManagedSet, policy metav1.Object) bool {
+	return policy == nil || !isOwnedBy(set.Object.GetLabels(), policy)
+}
+
+// RequestRefresh annotates the networkset to be resolved immediately, the reconcilers remove the annotation
+func RequestRefresh(obj metav1.Object, now time.Time) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[refreshAnnotation] = now.UTC().Format(time.RFC3339)
+	obj.SetAnnotations(annotations)
+}
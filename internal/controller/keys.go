@@ -0,0 +1,62 @@
+package controller
+
+import "strings"
+
+// Keys are the label keys and the annotation prefix of the managed networksets
+type Keys struct {
+	// ControlPlaneLabel with ControlPlaneValue marks networksets managed by the controller
+	ControlPlaneLabel string
+	ControlPlaneValue string
+	// ParentPolicyLabel and ParentPolicyUIDLabel reference the policy which owns the networkset
+	ParentPolicyLabel    string
+	ParentPolicyUIDLabel string
+	// AnnotationPrefix is the prefix of the controller annotations and of the domainset label
+	AnnotationPrefix string
+}
+
+// defaultKeys are used when the keys are not configured
+var defaultKeys = Keys{
+	ControlPlaneLabel:    "control-plane",
+	ControlPlaneValue:    "networksets-operator",
+	ParentPolicyLabel:    "parent-networkPolicy",
+	ParentPolicyUIDLabel: "parent-networkPolicy-uid",
+	AnnotationPrefix:     "networksets.javdet.io/",
+}
+
+// DefaultKeys returns the keys used when they are not configured
+func DefaultKeys() Keys {
+	return defaultKeys
+}
+
+// prefixedKeys are the annotation and label keys built from the annotation prefix
+var prefixedKeys = []*string{
+	&addressFamilyAnnotation,
+	&accumulateAnnotation,
+	&maxAddressesAnnotation,
+	&lastSeenAnnotation,
+	&netsHashAnnotation,
+	&manualOverrideAnnotation,
+	&resolveFailedSinceAnnotation,
+	&lastErrorAnnotation,
+	&lastResolveAnnotation,
+	&lastChangeAnnotation,
+	&resolvedCountAnnotation,
+	&sourceAnnotation,
+	&refreshAnnotation,
+	&allowedDomainsAnnotation,
+	&domainSetLabel,
+}
+
+// SetKeys replaces the label keys and the annotation prefix.
+// It must be called before the reconcilers are started, the networksets labeled with the previous keys are not managed
+func SetKeys(keys Keys) {
+	controlPlaneLabel, controlPlaneValue = keys.ControlPlaneLabel, keys.ControlPlaneValue
+	parentPolicyLabel, parentPolicyUIDLabel = keys.ParentPolicyLabel, keys.ParentPolicyUIDLabel
+	for _, key := range prefixedKeys {
+		*key = keys.AnnotationPrefix + strings.TrimPrefix(*key, annotationPrefix)
+	}
+	for i, key := range optionAnnotations {
+		optionAnnotations[i] = keys.AnnotationPrefix + strings.TrimPrefix(key, annotationPrefix)
+	}
+	annotationPrefix = keys.AnnotationPrefix
+}
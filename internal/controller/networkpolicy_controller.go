@@ -18,16 +18,19 @@ package controller
 
 import (
 	"context"
-	"fmt"
-
-	"net"
-	"regexp"
+	"slices"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/internal/resolver"
 	"github.com/javdet/networksets-controller/monitoring"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -35,55 +38,55 @@ import (
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 )
 
-// NetworkPolicyReconciler reconciles a Networkset object
+// NetworkPolicyReconciler creates and updates the networksets of the resolver labels of NetworkPolicy
 type NetworkPolicyReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Resolvers *resolver.Registry
+	// Settings are loaded at the start of every reconcile, they are replaced when the configuration is reloaded
+	Settings *SettingsStore
+	// MaxConcurrentReconciles is the number of concurrent reconciles, 2 if not set
+	MaxConcurrentReconciles int
+	// Recorder records events of the networksets on the policy
+	Recorder record.EventRecorder
 }
 
-var destinationSelector = regexp.MustCompile(`^DNS_RESOLVER\s==\s'(?P<domain>.*)'$`)
-
 var controllerNetworksetsLog = ctrl.Log.WithName("controller").WithName("Networkpolicy")
 
 //+kubebuilder:rbac:groups=crd.projectcalico.org,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=crd.projectcalico.org,resources=networkpolicies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=crd.projectcalico.org,resources=networkpolicies/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Memcached object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
+// Reconcile creates and updates the networksets of the resolver terms of the networkpolicy,
+// the networksets of the terms removed from the rules are deleted
 func (r *NetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
 	controllerNetworksetsLog.Info("start reconcile", "request", req.NamespacedName)
+	settings := r.Settings.Load()
+	if !settings.Namespaces.Allowed(req.Namespace) {
+		return ctrl.Result{}, nil
+	}
 	instance := &calicov3.NetworkPolicy{}
 	networkSetList := &calicov3.NetworkSetList{}
 
-	opts := []client.ListOption{
-		client.InNamespace(req.NamespacedName.Namespace),
-		client.MatchingLabels{
-			"control-plane":        "networksets-operator",
-			"parent-networkPolicy": req.NamespacedName.Name,
-		},
-	}
-	err := r.List(ctx, networkSetList, opts...)
+	err := r.List(ctx, networkSetList, client.InNamespace(req.NamespacedName.Namespace))
 	if err != nil {
 		controllerNetworksetsLog.Error(err, "cannot get object NetworkSet list")
 		return ctrl.Result{}, err
 	}
 
 	err = r.Get(ctx, req.NamespacedName, instance)
+	networkSetList.Items = slices.DeleteFunc(networkSetList.Items, func(networkSet calicov3.NetworkSet) bool {
+		return !isManagedBy(&networkSet, req.NamespacedName.Name, instance.GetUID())
+	})
 	if err != nil {
 		controllerNetworksetsLog.Error(err, "cannot get object NetworkPolicy")
 		if apierrors.IsNotFound(err) {
-			deleteNetworkSetList := r.getNetworkSetList(req.NamespacedName.Name, req.NamespacedName.Namespace, networkSetList)
-			for _, networkSet := range deleteNetworkSetList.Items {
+			// the list contains networksets labeled by the name of the deleted policy only
+			for _, networkSet := range networkSetList.Items {
 				if networkSet.GetName() != "" {
 					controllerNetworksetsLog.Info("Remove networkset", "name", networkSet.GetName())
 					err = r.Delete(
@@ -92,9 +95,10 @@ func (r *NetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 					)
 					if err != nil {
 						controllerNetworksetsLog.Error(err, "cannot delete NetworkSet")
+						monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationDelete)
 						return ctrl.Result{}, err
 					}
-					monitoring.NetworksetControllerNetworksetDeleted.Inc()
+					monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationDelete)
 				}
 			}
 			return ctrl.Result{}, nil
@@ -102,43 +106,66 @@ func (r *NetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
-	var domain string
-	for ruleNumber, rule := range instance.Spec.Egress {
-		matches := destinationSelector.FindStringSubmatch(rule.Destination.Selector)
-		if len(matches) > 1 {
-			domain = matches[1]
-			controllerNetworksetsLog.Info("Found domain", "request", req.NamespacedName, "domain", domain)
-			ipAddress, err := resolveDomain(domain)
+	var label, domain string
+	terms := resolverTerms(controllerNetworksetsLog, r.Resolvers, ruleSelectors(instance.Spec.Ingress, instance.Spec.Egress))
+	terms, err = r.allowedTerms(ctx, instance, terms, settings.RequireAllowedDomains)
+	if err != nil {
+		controllerNetworksetsLog.Error(err, "cannot get allowed domains", "request", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+	err = r.pruneNetworkSets(ctx, instance, networkSetList, terms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, term := range terms {
+		label, domain = term.Key, term.Value
+		controllerNetworksetsLog.Info("Found domain", "request", req.NamespacedName, "label", label, "domain", domain)
+		// the networkset is created or updated even when the domain is not resolved, the networkset controller retries the resolve
+		prefixes, resolveErr := r.Resolvers.Resolve(ctx, label, domain)
+		if resolveErr != nil {
+			controllerNetworksetsLog.Error(resolveErr, "cannot resolve domain", "request", req.NamespacedName, "label", label, "domain", domain)
+			r.Recorder.Eventf(instance, corev1.EventTypeWarning, reasonResolveFailed, "cannot resolve %s == '%s': %s", label, domain, resolveErr)
+		}
+		family := getAddressFamily(instance.GetAnnotations(), settings.AddressFamily)
+		ipAddress := resolver.FormatPrefixes(family.Filter(prefixes))
+		source := r.Resolvers.Source(label, domain)
+		now := time.Now()
+
+		networkSet := r.getNetworkSet(instance, label, domain, networkSetList)
+		if networkSet.GetName() != "" {
+			original := networkSet.DeepCopy()
+			networkSet = updateNetworkset(instance, networkSet, label, domain, ipAddress)
+			networkSet.Spec.Nets, _ = managedNets(networkSet.GetAnnotations(), original.Spec.Nets, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			match, err := arraysMatch(networkSet.Spec.Nets, original.Spec.Nets)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-
-			networkSet := r.getNetworkSet(req.NamespacedName.Name, req.NamespacedName.Namespace, domain, networkSetList)
-			if networkSet.GetName() != "" {
-				controllerNetworksetsLog.Info("Update existing networkset", "request", req.NamespacedName, "name", fmt.Sprint(req.NamespacedName.Name, "-", ruleNumber))
-				err = r.Update(
-					ctx,
-					updateNetworkset(instance, networkSet, domain, ipAddress),
-				)
-				if err != nil {
-					controllerNetworksetsLog.Error(err, "cannot update NetworkSet", "name", fmt.Sprint(req.NamespacedName.Name, "-", transformDomain(domain)))
-					monitoring.NetworksetControllerNetworksetUpdateFailed.Inc()
-					return ctrl.Result{}, err
-				}
-				monitoring.NetworksetControllerNetworksetUpdated.Inc()
-			} else {
-				controllerNetworksetsLog.Info("Create networkset", "request", req.NamespacedName, "name", fmt.Sprint(req.NamespacedName.Name, "-", transformDomain(domain)))
-				err = r.Create(
-					ctx,
-					createNetworkset(instance, domain, ipAddress),
-				)
-				if err != nil {
-					controllerNetworksetsLog.Error(err, "cannot create NetworkSet", "name", fmt.Sprint(req.NamespacedName.Name, "-", transformDomain(domain)))
-					monitoring.NetworksetControllerNetworksetCreationFailed.Inc()
-					return ctrl.Result{}, err
-				}
-				monitoring.NetworksetControllerNetworksetCreated.Inc()
+			setResolveStatus(networkSet.GetAnnotations(), source, len(ipAddress), resolveErr, !match || resolveFailed(original.GetAnnotations()), settings.RefreshInterval, now)
+			// unchanged networksets are not updated, the update triggers reconcile of the networkset
+			if match && equality.Semantic.DeepEqual(networkSet.ObjectMeta, original.ObjectMeta) {
+				continue
+			}
+			controllerNetworksetsLog.Info("Update existing networkset", "request", req.NamespacedName, "name", networkSet.GetName())
+			err = r.Update(ctx, networkSet)
+			if err != nil {
+				controllerNetworksetsLog.Error(err, "cannot update NetworkSet", "name", networkSet.GetName())
+				monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+				return ctrl.Result{}, err
+			}
+			monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+		} else {
+			networkSet = newNetworkset(instance, label, domain, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			setResolveStatus(networkSet.GetAnnotations(), source, len(ipAddress), resolveErr, true, settings.RefreshInterval, now)
+			controllerNetworksetsLog.Info("Create networkset", "request", req.NamespacedName, "name", networkSet.GetName())
+			err = r.Create(ctx, networkSet)
+			if err != nil {
+				controllerNetworksetsLog.Error(err, "cannot create NetworkSet", "name", networkSet.GetName())
+				monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationCreate)
+				return ctrl.Result{}, err
 			}
+			monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationCreate)
+			r.Recorder.Eventf(instance, corev1.EventTypeNormal, reasonNetworkSetCreated, "NetworkSet %s is created for %s == '%s'", networkSet.GetName(), label, domain)
 		}
 	}
 
@@ -146,25 +173,14 @@ func (r *NetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// Deleted networksets and networksets with edited labels are restored,
+// the policies are reconciled again when the allowlist of their namespace is changed
 func (r *NetworkPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&calicov3.NetworkPolicy{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 2}).
+		Owns(&calicov3.NetworkSet{}, builder.WithPredicates(ownedSetPredicate)).
+		Watches(&corev1.Namespace{}, enqueueNamespaceObjects(mgr.GetClient(), &calicov3.NetworkPolicyList{}),
+			builder.WithPredicates(allowlistChangedPredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles(r.MaxConcurrentReconciles)}).
 		Complete(r)
 }
-
-func resolveDomain(domain string) ([]string, error) {
-	ips, err := net.LookupIP(domain)
-	if err != nil {
-		controllerNetworksetsLog.Error(err, "Error resolving domain")
-		monitoring.NetworksetControllerResolveFailed.Inc()
-		return []string{}, err
-	}
-	var ipStrings []string
-	for _, ip := range ips {
-		ipStrings = append(ipStrings, fmt.Sprint(ip.String(), "/32"))
-	}
-	monitoring.NetworksetControllerResolveSuccesful.Inc()
-
-	return ipStrings, nil
-}
@@ -1,26 +1,59 @@
 package controller
 
 import (
-	"fmt"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"strings"
+	"time"
 
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"github.com/javdet/networksets-controller/internal/selector"
+	"github.com/javdet/networksets-controller/monitoring"
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-const selectorName = "DNS_RESOLVER"
+var (
+	// controlPlaneLabel marks networksets managed by the controller
+	controlPlaneLabel = defaultKeys.ControlPlaneLabel
+	controlPlaneValue = defaultKeys.ControlPlaneValue
+	// parentPolicyLabel and parentPolicyUIDLabel reference the policy which owns the networkset
+	parentPolicyLabel    = defaultKeys.ParentPolicyLabel
+	parentPolicyUIDLabel = defaultKeys.ParentPolicyUIDLabel
+)
+
+// annotationPrefix is the prefix of the controller annotations
+var annotationPrefix = defaultKeys.AnnotationPrefix
+
+// addressFamilyAnnotation selects IPv4, IPv6 or Dual stack networks of the policy
+var addressFamilyAnnotation = annotationPrefix + "address-family"
 
-func createNetworkset(instance *calicov3.NetworkPolicy, domain string, ipAddress []string) *calicov3.NetworkSet {
+// optionAnnotations are copied from the policy to its networksets
+var optionAnnotations = []string{
+	addressFamilyAnnotation,
+	accumulateAnnotation,
+	maxAddressesAnnotation,
+}
+
+func createNetworkset(instance *calicov3.NetworkPolicy, label string, domain string, ipAddress []string) *calicov3.NetworkSet {
 	return &calicov3.NetworkSet{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "NetworkSet",
 			APIVersion: "projectcalico.org/v3",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        fmt.Sprint(instance.GetName(), "-", transformDomain(domain)),
+			Name:        networkSetName(instance.GetName(), label, domain),
 			Namespace:   instance.GetNamespace(),
-			Labels:      getLabels(instance.GetName(), domain),
-			Annotations: getAnnotations(),
+			Labels:      getLabels(instance, label, domain),
+			Annotations: getAnnotations(instance.GetAnnotations()),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(instance, calicov3.SchemeGroupVersion.WithKind(calicov3.KindNetworkPolicy)),
+			},
 		},
 		Spec: calicov3.NetworkSetSpec{
 			Nets: ipAddress,
@@ -28,34 +61,122 @@ func createNetworkset(instance *calicov3.NetworkPolicy, domain string, ipAddress
 	}
 }
 
-func updateNetworkset(instance *calicov3.NetworkPolicy, networkSet *calicov3.NetworkSet, domain string, ipAddress []string) *calicov3.NetworkSet {
-	networkSet.SetLabels(getLabels(instance.GetName(), domain))
+// newNetworkset builds the networkset of the new policy, the networks are accumulated
+// and the failure of the resolve is recorded in the annotations
+func newNetworkset(instance *calicov3.NetworkPolicy, label string, domain string, ipAddress []string, resolveErr error, family resolver.AddressFamily, failurePolicy FailurePolicy, now time.Time) *calicov3.NetworkSet {
+	networkSet := createNetworkset(instance, label, domain, ipAddress)
+	networkSet.Spec.Nets, _ = managedNets(networkSet.GetAnnotations(), nil, ipAddress, resolveErr, family, failurePolicy, now)
+	return networkSet
+}
+
+func updateNetworkset(instance *calicov3.NetworkPolicy, networkSet *calicov3.NetworkSet, label string, domain string, ipAddress []string) *calicov3.NetworkSet {
+	adoptNetworkset(networkSet, instance, calicov3.KindNetworkPolicy, label, domain)
+	networkSet.SetAnnotations(updateAnnotations(networkSet.GetAnnotations(), instance.GetAnnotations()))
 	networkSet.Spec.Nets = ipAddress
 	return networkSet
 }
 
 // getLabels get common labels
-func getLabels(name string, domain string) map[string]string {
+func getLabels(policy metav1.Object, label string, domain string) map[string]string {
 	return map[string]string{
-		selectorName:           domain,
-		"parent-networkPolicy": name,
-		"control-plane":        "networksets-operator",
+		label:                domain,
+		parentPolicyLabel:    policy.GetName(),
+		parentPolicyUIDLabel: string(policy.GetUID()),
+		controlPlaneLabel:    controlPlaneValue,
 	}
 }
 
-// getAnnotations get common annotations
-func getAnnotations() map[string]string {
-	return map[string]string{
-		"operator":      "networksets",
-		"control-plane": "networksets-operator",
+// adoptNetworkset restores the labels and the controller reference of the networkset of the policy.
+// The networkset without the uid label and the controller reference, like the rendered one committed to the GitOps
+// repository, is kept without them, so the GitOps tools do not report it as changed.
+// The networkset created by the webhook has the uid label of the policy and gets the controller reference
+func adoptNetworkset(networkSet metav1.Object, policy metav1.Object, kind string, label string, domain string) {
+	uid, labeled := networkSet.GetLabels()[parentPolicyUIDLabel]
+	labels := getLabels(policy, label, domain)
+	if !labeled {
+		delete(labels, parentPolicyUIDLabel)
+	}
+	networkSet.SetLabels(labels)
+	if metav1.GetControllerOf(networkSet) != nil || (labeled && uid == string(policy.GetUID())) {
+		networkSet.SetOwnerReferences(updateControllerRef(networkSet.GetOwnerReferences(),
+			metav1.NewControllerRef(policy, calicov3.SchemeGroupVersion.WithKind(kind))))
+	}
+}
+
+// updateControllerRef replaces the controller reference, other owner references are kept
+func updateControllerRef(references []metav1.OwnerReference, controllerRef *metav1.OwnerReference) []metav1.OwnerReference {
+	result := []metav1.OwnerReference{*controllerRef}
+	for _, reference := range references {
+		if reference.Controller == nil || !*reference.Controller {
+			result = append(result, reference)
+		}
+	}
+	return result
+}
+
+// isOwnedBy reports whether the networkset labels reference the policy.
+// Networksets created before the uid label was introduced are owned by the policy with the same name
+func isOwnedBy(labels map[string]string, policy metav1.Object) bool {
+	if labels[parentPolicyLabel] != policy.GetName() {
+		return false
+	}
+	uid, ok := labels[parentPolicyUIDLabel]
+	return !ok || uid == string(policy.GetUID())
+}
+
+// isManagedBy reports whether the networkset is labeled by the name of the policy or controlled by the policy,
+// the labels of the controlled networkset could be edited manually
+func isManagedBy(networkSet metav1.Object, policyName string, policyUID types.UID) bool {
+	labels := networkSet.GetLabels()
+	if labels[controlPlaneLabel] == controlPlaneValue && labels[parentPolicyLabel] == policyName {
+		return true
 	}
+	controllerRef := metav1.GetControllerOf(networkSet)
+	return policyUID != "" && controllerRef != nil && controllerRef.UID == policyUID
 }
 
-func (r *NetworkPolicyReconciler) getNetworkSet(policyName string, PolicyNamespace string, domain string, networkSetList *calicov3.NetworkSetList) *calicov3.NetworkSet {
+// getAnnotations get common annotations and options of the policy
+func getAnnotations(policyAnnotations map[string]string) map[string]string {
+	return updateAnnotations(map[string]string{
+		"operator":        "networksets",
+		controlPlaneLabel: controlPlaneValue,
+	}, policyAnnotations)
+}
+
+// updateAnnotations copies options of the policy to the networkset annotations
+func updateAnnotations(annotations map[string]string, policyAnnotations map[string]string) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for _, key := range optionAnnotations {
+		if value, ok := policyAnnotations[key]; ok {
+			annotations[key] = value
+		} else {
+			delete(annotations, key)
+		}
+	}
+	return annotations
+}
+
+// getAddressFamily returns address family from the annotations or the default one
+func getAddressFamily(annotations map[string]string, defaultFamily resolver.AddressFamily) resolver.AddressFamily {
+	value, ok := annotations[addressFamilyAnnotation]
+	if !ok {
+		return defaultFamily
+	}
+	family, err := resolver.ParseAddressFamily(value)
+	if err != nil {
+		controllerNetworksetsLog.Error(err, "invalid annotation", "annotation", addressFamilyAnnotation)
+		return defaultFamily
+	}
+	return family
+}
+
+func (r *NetworkPolicyReconciler) getNetworkSet(instance *calicov3.NetworkPolicy, label string, domain string, networkSetList *calicov3.NetworkSetList) *calicov3.NetworkSet {
 	result := &calicov3.NetworkSet{}
 	for _, networkSet := range networkSetList.Items {
-		if strings.Contains(networkSet.GetName(), policyName) {
-			if networkSet.GetLabels()[selectorName] == domain {
+		if isOwnedBy(networkSet.GetLabels(), instance) {
+			if networkSet.GetLabels()[label] == domain {
 				result = &networkSet
 				break
 			}
@@ -65,19 +186,61 @@ func (r *NetworkPolicyReconciler) getNetworkSet(policyName string, PolicyNamespa
 	return result
 }
 
-func (r *NetworkPolicyReconciler) getNetworkSetList(policyName string, PolicyNamespace string, networkSetList *calicov3.NetworkSetList) *calicov3.NetworkSetList {
-	result := &calicov3.NetworkSetList{}
+// pruneNetworkSets deletes networksets of the policy which are not referenced by the rules anymore
+// and networksets left by the deleted policy with the same name
+func (r *NetworkPolicyReconciler) pruneNetworkSets(ctx context.Context, instance *calicov3.NetworkPolicy, networkSetList *calicov3.NetworkSetList, terms []selector.Term) error {
 	for _, networkSet := range networkSetList.Items {
-		if strings.Contains(networkSet.GetName(), policyName) {
-			result.Items = append(result.Items, networkSet)
+		if isOwnedBy(networkSet.GetLabels(), instance) && isDesiredTerm(r.Resolvers, networkSet.GetLabels(), terms) {
+			continue
+		}
+		controllerNetworksetsLog.Info("Remove unused networkset", "namespace", networkSet.GetNamespace(), "name", networkSet.GetName())
+		err := r.Delete(ctx, &networkSet)
+		if client.IgnoreNotFound(err) != nil {
+			controllerNetworksetsLog.Error(err, "cannot delete NetworkSet", "name", networkSet.GetName())
+			monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationDelete)
+			return err
+		}
+		monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationDelete)
+		if isOwnedBy(networkSet.GetLabels(), instance) {
+			r.Recorder.Eventf(instance, corev1.EventTypeNormal, reasonNetworkSetRemoved, "NetworkSet %s is removed, its domain is not referenced by the rules", networkSet.GetName())
 		}
 	}
 
-	return result
+	return nil
+}
+
+// networkSetName returns the name of the networkset of the policy resolving the value by the resolver label.
+// The value is converted to the DNS-1123 name and the name ends with the hash of the label and the value,
+// so the values of different resolvers and the values converted to the same name like my-site.com and my.site.com
+// get different networksets. The name is truncated to the maximal length before the hash
+func networkSetName(policyName string, label string, value string) string {
+	hash := sha256.Sum256([]byte(label + "\x00" + value))
+	suffix := "-" + hex.EncodeToString(hash[:])[:networkSetNameHashLength]
+	name := policyName
+	if domain := transformDomain(value); domain != "" {
+		name += "-" + domain
+	}
+	if len(name) > validation.DNS1123SubdomainMaxLength-len(suffix) {
+		name = strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-len(suffix)], "-.")
+	}
+	return name + suffix
 }
 
+// networkSetNameHashLength is the number of hex digits of the label and value hash in the networkset names
+const networkSetNameHashLength = 8
+
+// transformDomain converts the value to the lowercase letters, digits and dashes,
+// other characters like dots, colons and underscores are replaced by dashes
 func transformDomain(domain string) string {
-	domain = strings.ReplaceAll(domain, ".", "-")
-	domain = strings.ReplaceAll(domain, ":", "-")
-	return domain
+	domain = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, domain)
+	return strings.Trim(domain, "-")
 }
@@ -19,129 +19,269 @@ package controller
 import (
 	"context"
 	"fmt"
-	"net"
+	"maps"
+	"net/netip"
+	"slices"
 	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/internal/resolver"
 	"github.com/javdet/networksets-controller/monitoring"
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// NetworkPolicyReconciler reconciles a Networkset object
+// NetworkSetReconciler resolves the domain of the managed NetworkSet
 type NetworkSetReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Resolvers *resolver.Registry
+	// Settings are loaded at the start of every reconcile, they are replaced when the configuration is reloaded
+	Settings *SettingsStore
+	// MaxConcurrentReconciles is the number of concurrent reconciles, 2 if not set
+	MaxConcurrentReconciles int
+	// Recorder records failed and recovered resolves on the networkset
+	Recorder record.EventRecorder
+
+	schedule refreshSchedule
 }
 
 var controllerNetworksetLog = ctrl.Log.WithName("controller").WithName("Networksets")
 
+// Reconcile resolves the domain of the managed networkset and updates its networks when they change.
+// The networkset is requeued when the records TTL expires, failed resolves are retried with backoff
 func (r *NetworkSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
 	controllerNetworksetLog.Info("start reconcile", "request", req.NamespacedName)
-
-	networkSetList := &calicov3.NetworkSetList{}
-	opts := []client.ListOption{
-		client.MatchingLabels{
-			"control-plane": "networksets-operator",
-		},
+	settings := r.Settings.Load()
+	if !settings.Namespaces.Allowed(req.Namespace) {
+		return ctrl.Result{}, nil
 	}
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
 
-	for {
-		select {
-		case <-ctx.Done():
+	networkSet := &calicov3.NetworkSet{}
+	err := r.Get(ctx, req.NamespacedName, networkSet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			r.schedule.forget(req.NamespacedName.String())
+			monitoring.DeleteAddresses(monitoring.KindNetworkSet, req.Namespace, req.Name)
 			return ctrl.Result{}, nil
-		case <-ticker.C:
-			// Resolve the domain names and update the NetworkSet
-			err := r.List(ctx, networkSetList, opts...)
-			if err != nil {
-				controllerNetworksetLog.Error(err, "cannot get object NetworkSet list")
-				return ctrl.Result{}, err
-			}
-
-			for _, networkSet := range networkSetList.Items {
-				domain := networkSet.GetLabels()[selectorName]
-				newIpAddress, err := resolveDomain(domain)
-				if err != nil {
-					return ctrl.Result{}, err
-				}
-				oldIpAddress := networkSet.Spec.Nets
-				match, err := arraysMatch(newIpAddress, oldIpAddress)
-				if err != nil {
-					return ctrl.Result{}, err
-				}
-
-				if !match {
-					controllerNetworksetLog.Info("Update dns networkset", "Networkset", networkSet.GetName())
-					err = r.Update(
-						ctx,
-						updateNetworkset(&calicov3.NetworkPolicy{
-							ObjectMeta: metav1.ObjectMeta{
-								Name: networkSet.GetLabels()["parent-networkPolicy"],
-							},
-						},
-							&networkSet,
-							domain,
-							newIpAddress),
-					)
-					if err != nil {
-						controllerNetworksetLog.Error(err, "cannot update NetworkSet", "name", fmt.Sprint(req.NamespacedName.Name, "-", transformDomain(domain)))
-						monitoring.NetworksetControllerNetworksetUpdateFailed.Inc()
-						return ctrl.Result{}, err
-					}
-					monitoring.NetworksetControllerNetworksetUpdated.Inc()
-				}
-			}
 		}
+		controllerNetworksetLog.Error(err, "cannot get object NetworkSet")
+		return ctrl.Result{}, err
+	}
+	if networkSet.GetLabels()[controlPlaneLabel] != controlPlaneValue {
+		return ctrl.Result{}, nil
+	}
+	label, domain, ok := r.Resolvers.Match(networkSet.GetLabels())
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	// the networks are written by the policy controller as well
+	monitoring.SetAddresses(monitoring.KindNetworkSet, networkSet.GetNamespace(), networkSet.GetName(), domain, len(networkSet.Spec.Nets))
+
+	// the networks edited manually are kept with the manual override
+	if isManualOverride(networkSet.GetAnnotations()) {
+		return ctrl.Result{}, nil
+	}
+
+	// the networkset labeled manually does not bypass the allowlist of the namespace
+	allowlist, err := namespaceAllowlist(ctx, r.Client, networkSet.GetNamespace(), settings.RequireAllowedDomains)
+	if err != nil {
+		controllerNetworksetLog.Error(err, "cannot get allowed domains", "request", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+	if !allowlist.allows(label, domain) {
+		r.schedule.forget(req.NamespacedName.String())
+		return ctrl.Result{}, r.denyNetworkSet(ctx, networkSet, label, domain)
+	}
+
+	// updates of the networkset trigger reconcile as well, it is not resolved before the scheduled time
+	// unless the networks were edited manually or the refresh is requested by the annotation
+	now := time.Now()
+	refresh := refreshRequested(networkSet.GetAnnotations())
+	if refresh {
+		controllerNetworksetLog.Info("Refresh requested", "name", networkSet.GetName(), "domain", domain)
+		r.Resolvers.Invalidate(label, domain)
+	} else if netsDrifted(networkSet.GetAnnotations(), networkSet.Spec.Nets) {
+		controllerNetworksetLog.Info("Revert manually edited networks", "name", networkSet.GetName())
+	} else if remaining := r.schedule.remaining(req.NamespacedName.String(), now); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	prefixes, ttl, resolveErr := r.Resolvers.ResolveTTL(ctx, label, domain)
+	var interval time.Duration
+	if resolveErr != nil {
+		interval = r.schedule.failed(req.NamespacedName.String(), now, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		controllerNetworksetLog.Error(resolveErr, "cannot resolve domain, retry later", "name", networkSet.GetName(), "domain", domain, "retry", interval)
+	} else {
+		if ttl == 0 {
+			ttl = settings.RefreshInterval
+		}
+		interval = refreshInterval(ttl, settings.MinRefreshInterval, settings.MaxRefreshInterval)
+	}
+
+	family := getAddressFamily(networkSet.GetAnnotations(), settings.AddressFamily)
+	newIpAddress := resolver.FormatPrefixes(family.Filter(prefixes))
+	oldIpAddress := networkSet.Spec.Nets
+	wasFailed := resolveFailed(networkSet.GetAnnotations())
+	annotations := maps.Clone(networkSet.GetAnnotations())
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, refreshAnnotation)
+	resolvedCount := len(newIpAddress)
+	newIpAddress, expires := managedNets(annotations, oldIpAddress, newIpAddress, resolveErr, family, settings.FailurePolicy, now)
+	if resolveErr == nil {
+		// accumulated networks are removed when they expire
+		if !expires.IsZero() && expires.Sub(now) < interval {
+			interval = refreshInterval(expires.Sub(now), settings.MinRefreshInterval, settings.MaxRefreshInterval)
+		}
+		r.schedule.succeeded(req.NamespacedName.String(), now.Add(interval))
+	}
+	match, err := arraysMatch(newIpAddress, oldIpAddress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	setResolveStatus(annotations, r.Resolvers.Source(label, domain), resolvedCount, resolveErr, !match || wasFailed, settings.RefreshInterval, now)
+
+	if !match || !maps.Equal(annotations, networkSet.GetAnnotations()) {
+		controllerNetworksetLog.Info("Update dns networkset", "Networkset", networkSet.GetName())
+		networkSet.SetAnnotations(annotations)
+		networkSet.Spec.Nets = newIpAddress
+		err = r.Update(ctx, networkSet)
+		if err != nil {
+			controllerNetworksetLog.Error(err, "cannot update NetworkSet", "name", networkSet.GetName())
+			monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+			r.schedule.forget(req.NamespacedName.String())
+			return ctrl.Result{}, err
+		}
+		monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+		added, removed := networksChurn(oldIpAddress, newIpAddress)
+		monitoring.AddAddressChurn(label, domain, added, removed)
+		monitoring.SetAddresses(monitoring.KindNetworkSet, networkSet.GetNamespace(), networkSet.GetName(), domain, len(newIpAddress))
 	}
+
+	if refresh && resolveErr == nil {
+		r.Recorder.Eventf(networkSet, corev1.EventTypeNormal, reasonRefreshed, "%s == '%s' is refreshed on request, %d networks", label, domain, len(newIpAddress))
+	}
+	// events are recorded when the resolve starts failing and when it recovers
+	switch failed := resolveFailed(annotations); {
+	case failed && !wasFailed:
+		r.Recorder.Eventf(networkSet, corev1.EventTypeWarning, reasonResolveFailed, "cannot resolve %s == '%s': %s", label, domain, resolveErr)
+	case !failed && wasFailed:
+		r.Recorder.Eventf(networkSet, corev1.EventTypeNormal, reasonResolved, "%s == '%s' is resolved again", label, domain)
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// denyNetworkSet removes the networks of the networkset whose domain is not allowed in its namespace
+func (r *NetworkSetReconciler) denyNetworkSet(ctx context.Context, networkSet *calicov3.NetworkSet, label string, domain string) error {
+	if len(networkSet.Spec.Nets) == 0 {
+		return nil
+	}
+	controllerNetworksetLog.Info("Remove networks of the domain which is not allowed", "namespace", networkSet.GetNamespace(), "name", networkSet.GetName(), "domain", domain)
+	annotations := maps.Clone(networkSet.GetAnnotations())
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, refreshAnnotation)
+	delete(annotations, lastSeenAnnotation)
+	setNetsHash(annotations, nil)
+	oldIpAddress := networkSet.Spec.Nets
+	networkSet.SetAnnotations(annotations)
+	networkSet.Spec.Nets = []string{}
+	if err := r.Update(ctx, networkSet); err != nil {
+		controllerNetworksetLog.Error(err, "cannot update NetworkSet", "name", networkSet.GetName())
+		monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+		return err
+	}
+	monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationUpdate)
+	monitoring.AddAddressChurn(label, domain, 0, len(oldIpAddress))
+	monitoring.SetAddresses(monitoring.KindNetworkSet, networkSet.GetNamespace(), networkSet.GetName(), domain, 0)
+	r.Recorder.Eventf(networkSet, corev1.EventTypeWarning, reasonDomainNotAllowed, "%s == '%s' is not allowed in namespace %s, the networks are removed", label, domain, networkSet.GetNamespace())
+	return nil
 }
 
+// SetupWithManager sets up the controller with the Manager.
+// The networksets are reconciled again when the allowlist of their namespace is changed
 func (r *NetworkSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&calicov3.NetworkSet{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Watches(&corev1.Namespace{}, enqueueNamespaceObjects(mgr.GetClient(), &calicov3.NetworkSetList{}, client.MatchingLabels{controlPlaneLabel: controlPlaneValue}),
+			builder.WithPredicates(allowlistChangedPredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles(r.MaxConcurrentReconciles)}).
 		Complete(r)
 }
 
+// subnetsMatch compares networks in canonical form, so IPv4-mapped IPv6 and IPv4 networks are equal
 func subnetsMatch(subnet1, subnet2 string) (bool, error) {
-	_, ipNet1, err1 := net.ParseCIDR(subnet1)
-	_, ipNet2, err2 := net.ParseCIDR(subnet2)
+	prefix1, err1 := resolver.ParsePrefix(subnet1)
+	prefix2, err2 := resolver.ParsePrefix(subnet2)
 
 	if err1 != nil || err2 != nil {
 		return false, fmt.Errorf("invalid CIDR notation")
 	}
 
-	return ipNet1.String() == ipNet2.String(), nil
+	return prefix1 == prefix2, nil
 }
 
+// arraysMatch compares sets of networks of both address families, the order and duplicates are ignored
 func arraysMatch(array1, array2 []string) (bool, error) {
-	if len(array1) != len(array2) {
+	prefixes1, err := canonicalPrefixes(array1)
+	if err != nil {
+		return false, err
+	}
+	prefixes2, err := canonicalPrefixes(array2)
+	if err != nil {
+		return false, err
+	}
+	if len(prefixes1) != len(prefixes2) {
 		return false, nil
 	}
 
-	// Sort the arrays
-	sort.Strings(array1)
-	sort.Strings(array2)
-
-	// Compare sorted arrays
-	for i := range array1 {
-		match, err := subnetsMatch(array1[i], array2[i])
-		if err != nil {
-			return false, err
-		}
-		if !match {
+	for i := range prefixes1 {
+		if prefixes1[i] != prefixes2[i] {
 			return false, nil
 		}
 	}
 
 	return true, nil
 }
+
+// networksChurn returns the number of networks added to and removed from the old networks
+func networksChurn(oldNets, newNets []string) (int, int) {
+	added, removed := networksDiff(oldNets, newNets)
+	return len(added), len(removed)
+}
+
+// canonicalPrefixes parses networks and returns them sorted without duplicates,
+// IPv4 networks go before IPv6 ones
+func canonicalPrefixes(nets []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(nets))
+	for _, network := range nets {
+		prefix, err := resolver.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR notation %q", network)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	return slices.Compact(prefixes), nil
+}
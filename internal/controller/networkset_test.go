@@ -0,0 +1,94 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestNetworkSetName(t *testing.T) {
+	for _, tc := range []struct {
+		label  string
+		value  string
+		prefix string
+	}{
+		{label: "DNS_RESOLVER", value: "example.com", prefix: "policy-example-com-"},
+		{label: "DNS_RESOLVER", value: "Example.COM.", prefix: "policy-example-com-"},
+		{label: "HTTP_RESOLVER", value: "Web_Prod", prefix: "policy-web-prod-"},
+		{label: "DNS_RESOLVER", value: "2001:db8::1", prefix: "policy-2001-db8--1-"},
+		{label: "HTTP_RESOLVER", value: "___", prefix: "policy-"},
+		{label: "DNS_RESOLVER", value: strings.Repeat("sub.", 100) + "example.com", prefix: "policy-sub-sub-"},
+	} {
+		name := networkSetName("policy", tc.label, tc.value)
+		if !strings.HasPrefix(name, tc.prefix) {
+			t.Errorf("name of %s == '%s' is %q, expected prefix %q", tc.label, tc.value, name, tc.prefix)
+		}
+		if name[len(name)-networkSetNameHashLength-1] != '-' {
+			t.Errorf("name of %s == '%s' is %q, expected the hash suffix", tc.label, tc.value, name)
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			t.Errorf("name of %s == '%s' is %q: %s", tc.label, tc.value, name, msg)
+		}
+		if name != networkSetName("policy", tc.label, tc.value) {
+			t.Errorf("name of %s == '%s' is not stable", tc.label, tc.value)
+		}
+	}
+}
+
+func TestNetworkSetNameUnique(t *testing.T) {
+	for _, pair := range [][2][2]string{
+		{{"DNS_RESOLVER", "example.com"}, {"HTTP_RESOLVER", "example.com"}},
+		{{"DNS_RESOLVER", "my-site.com"}, {"DNS_RESOLVER", "my.site.com"}},
+		{{"HTTP_RESOLVER", "web_prod"}, {"HTTP_RESOLVER", "Web-Prod"}},
+		{{"DNS_RESOLVER", strings.Repeat("a", 300) + ".com"}, {"DNS_RESOLVER", strings.Repeat("a", 300) + ".org"}},
+	} {
+		first := networkSetName("policy", pair[0][0], pair[0][1])
+		second := networkSetName("policy", pair[1][0], pair[1][1])
+		if first == second {
+			t.Errorf("%s == '%s' and %s == '%s' have the same name %q", pair[0][0], pair[0][1], pair[1][0], pair[1][1], first)
+		}
+	}
+}
+
+func TestAdoptPrepopulatedNetworkset(t *testing.T) {
+	policy := &calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy", UID: "uid"}}
+	for _, tc := range []struct {
+		name    string
+		uid     string
+		ownerID string
+	}{
+		{name: "uid of the policy", uid: "uid", ownerID: "uid"},
+		{name: "uid of another policy", uid: "other-uid"},
+	} {
+		prepopulated := createNetworkset(policy, "DNS_RESOLVER", "example.com", nil)
+		prepopulated.SetOwnerReferences(nil)
+		prepopulated.Labels[parentPolicyUIDLabel] = tc.uid
+		updateNetworkset(policy, prepopulated, "DNS_RESOLVER", "example.com", nil)
+		ref := metav1.GetControllerOf(prepopulated)
+		switch {
+		case tc.ownerID == "" && ref != nil:
+			t.Errorf("%s: controller reference %v is added", tc.name, ref)
+		case tc.ownerID != "" && (ref == nil || string(ref.UID) != tc.ownerID):
+			t.Errorf("%s: controller reference is %v, expected %s", tc.name, ref, tc.ownerID)
+		}
+	}
+}
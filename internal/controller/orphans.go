@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/javdet/networksets-controller/monitoring"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OrphanCleaner removes managed networksets whose parent policy no longer exists
+// or was re-created with another uid. The networksets without the parent policy label are labeled manually and are kept.
+// It runs once at startup, the policies deleted while the controller was down are not reconciled.
+type OrphanCleaner struct {
+	client.Client
+}
+
+var orphanCleanerLog = ctrl.Log.WithName("controller").WithName("OrphanCleaner")
+
+// Start implements manager.Runnable
+func (c *OrphanCleaner) Start(ctx context.Context) error {
+	if err := c.cleanNetworkSets(ctx); err != nil {
+		orphanCleanerLog.Error(err, "cannot remove orphaned NetworkSets")
+	}
+	if err := c.cleanGlobalNetworkSets(ctx); err != nil {
+		orphanCleanerLog.Error(err, "cannot remove orphaned GlobalNetworkSets")
+	}
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (c *OrphanCleaner) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *OrphanCleaner) cleanNetworkSets(ctx context.Context) error {
+	networkSetList := &calicov3.NetworkSetList{}
+	err := c.List(ctx, networkSetList, client.MatchingLabels{controlPlaneLabel: controlPlaneValue})
+	if err != nil {
+		return err
+	}
+	for _, networkSet := range networkSetList.Items {
+		// the networksets labeled manually have no parent policy
+		if networkSet.GetLabels()[parentPolicyLabel] == "" {
+			continue
+		}
+		policy := &calicov3.NetworkPolicy{}
+		err = c.Get(ctx, types.NamespacedName{
+			Namespace: networkSet.GetNamespace(),
+			Name:      networkSet.GetLabels()[parentPolicyLabel],
+		}, policy)
+		if err == nil && isOwnedBy(networkSet.GetLabels(), policy) || err != nil && !apierrors.IsNotFound(err) {
+			continue
+		}
+		orphanCleanerLog.Info("Remove orphaned networkset", "namespace", networkSet.GetNamespace(), "name", networkSet.GetName())
+		err = c.Delete(ctx, &networkSet)
+		if client.IgnoreNotFound(err) != nil {
+			monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationDelete)
+			return err
+		}
+		monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationDelete)
+	}
+
+	return nil
+}
+
+func (c *OrphanCleaner) cleanGlobalNetworkSets(ctx context.Context) error {
+	globalNetworkSetList := &calicov3.GlobalNetworkSetList{}
+	err := c.List(ctx, globalNetworkSetList, client.MatchingLabels{controlPlaneLabel: controlPlaneValue})
+	if err != nil {
+		return err
+	}
+	for _, globalNetworkSet := range globalNetworkSetList.Items {
+		if globalNetworkSet.GetLabels()[parentPolicyLabel] == "" {
+			continue
+		}
+		policy := &calicov3.GlobalNetworkPolicy{}
+		err = c.Get(ctx, types.NamespacedName{
+			Name: globalNetworkSet.GetLabels()[parentPolicyLabel],
+		}, policy)
+		if err == nil && isOwnedBy(globalNetworkSet.GetLabels(), policy) || err != nil && !apierrors.IsNotFound(err) {
+			continue
+		}
+		orphanCleanerLog.Info("Remove orphaned globalnetworkset", "name", globalNetworkSet.GetName())
+		err = c.Delete(ctx, &globalNetworkSet)
+		if client.IgnoreNotFound(err) != nil {
+			monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationDelete)
+			return err
+		}
+		monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationDelete)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOrphanCleaner(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := calicov3.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	managed := func(labels map[string]string) map[string]string {
+		labels[controlPlaneLabel] = controlPlaneValue
+		labels["DNS_RESOLVER"] = "example.com"
+		return labels
+	}
+	policy := &calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy", UID: "uid"}}
+	globalPolicy := &calicov3.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy", UID: "uid"}}
+	objects := []client.Object{
+		policy,
+		globalPolicy,
+		&calicov3.NetworkSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owned",
+			Labels: managed(map[string]string{parentPolicyLabel: "policy", parentPolicyUIDLabel: "uid"})}},
+		&calicov3.NetworkSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deleted-policy",
+			Labels: managed(map[string]string{parentPolicyLabel: "deleted"})}},
+		&calicov3.NetworkSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "recreated-policy",
+			Labels: managed(map[string]string{parentPolicyLabel: "policy", parentPolicyUIDLabel: "old-uid"})}},
+		&calicov3.NetworkSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "labeled-manually",
+			Labels: managed(map[string]string{})}},
+		&calicov3.GlobalNetworkSet{ObjectMeta: metav1.ObjectMeta{Name: "global-owned",
+			Labels: managed(map[string]string{parentPolicyLabel: "policy", parentPolicyUIDLabel: "uid"})}},
+		&calicov3.GlobalNetworkSet{ObjectMeta: metav1.ObjectMeta{Name: "global-deleted-policy",
+			Labels: managed(map[string]string{parentPolicyLabel: "deleted"})}},
+		&calicov3.GlobalNetworkSet{ObjectMeta: metav1.ObjectMeta{Name: "global-labeled-manually",
+			Labels: managed(map[string]string{parentPolicyLabel: ""})}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+	if err := (&OrphanCleaner{Client: c}).Start(context.Background()); err != nil {
+		t.Fatalf("cannot clean orphans: %v", err)
+	}
+
+	for name, kept := range map[string]bool{
+		"owned":            true,
+		"deleted-policy":   false,
+		"recreated-policy": false,
+		"labeled-manually": true,
+	} {
+		err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &calicov3.NetworkSet{})
+		if kept && err != nil {
+			t.Errorf("networkset %s is removed: %v", name, err)
+		}
+		if !kept && !apierrors.IsNotFound(err) {
+			t.Errorf("networkset %s is kept: %v", name, err)
+		}
+	}
+	for name, kept := range map[string]bool{
+		"global-owned":            true,
+		"global-deleted-policy":   false,
+		"global-labeled-manually": true,
+	} {
+		err := c.Get(context.Background(), client.ObjectKey{Name: name}, &calicov3.GlobalNetworkSet{})
+		if kept && err != nil {
+			t.Errorf("globalnetworkset %s is removed: %v", name, err)
+		}
+		if !kept && !apierrors.IsNotFound(err) {
+			t.Errorf("globalnetworkset %s is kept: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"github.com/javdet/networksets-controller/internal/selector"
+	"github.com/javdet/networksets-controller/monitoring"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// resolverKeySuffix is the suffix of the selector labels which look like resolver labels,
+// the unknown labels with this suffix are reported with warning
+const resolverKeySuffix = "_RESOLVER"
+
+// PolicyValidator validates resolver selectors of NetworkPolicy and GlobalNetworkPolicy.
+// Malformed selectors with the registered resolver labels and invalid names of the networksets are rejected,
+// unknown resolver labels and domains which are not resolved are reported with warnings.
+// With Prepopulate networksets of the new policy and of the terms added to the updated policy are created
+// before the policy is admitted, so its rules do not deny traffic until the controller reconciles the policy.
+// The webhook fails open by default, so the policy admitted while it is down gets its networksets from the controller
+type PolicyValidator struct {
+	Client    client.Client
+	Resolvers *resolver.Registry
+	// Settings provide the default address family, the failure policy and the managed namespaces
+	Settings *SettingsStore
+	// ResolveTimeout limits resolving of all domains of the admission request, the domains are not resolved
+	// for the warnings if zero
+	ResolveTimeout time.Duration
+	// Prepopulate creates networksets of the new and updated policy at admission time
+	Prepopulate bool
+}
+
+var policyWebhookLog = ctrl.Log.WithName("webhook").WithName("Policy")
+
+//+kubebuilder:webhook:path=/validate-projectcalico-org-v3-networkpolicy,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=projectcalico.org,resources=networkpolicies,verbs=create;update,versions=v3,name=vnetworkpolicy.networksets.javdet.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-projectcalico-org-v3-globalnetworkpolicy,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=projectcalico.org,resources=globalnetworkpolicies,verbs=create;update,versions=v3,name=vglobalnetworkpolicy.networksets.javdet.io,admissionReviewVersions=v1
+
+// SetupWebhookWithManager registers the webhooks of NetworkPolicy and GlobalNetworkPolicy
+func (v *PolicyValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	err := ctrl.NewWebhookManagedBy(mgr).
+		For(&calicov3.NetworkPolicy{}).
+		WithValidator(v).
+		Complete()
+	if err != nil {
+		return err
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&calicov3.GlobalNetworkPolicy{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *PolicyValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.admit(ctx, nil, obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *PolicyValidator) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	return v.admit(ctx, oldObj, newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v *PolicyValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// admit validates the new or updated policy and prepopulates its networksets, the old policy is nil on create
+func (v *PolicyValidator) admit(ctx context.Context, oldObj runtime.Object, obj runtime.Object) (admission.Warnings, error) {
+	resolves, cancel := v.newRequestResolves(ctx)
+	defer cancel()
+	warnings, err := v.validate(ctx, obj, resolves)
+	if err != nil || !v.Prepopulate {
+		return warnings, err
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return warnings, err
+	}
+	if req.DryRun != nil && *req.DryRun {
+		return warnings, nil
+	}
+	// the policy is not admitted until its networksets exist
+	if err := v.prepopulate(ctx, req.Namespace, oldObj, obj, resolves); err != nil {
+		policyWebhookLog.Error(err, "cannot create networksets of the policy", "namespace", req.Namespace, "name", req.Name)
+		return warnings, fmt.Errorf("cannot create networksets of the policy: %w", err)
+	}
+	return warnings, nil
+}
+
+func (v *PolicyValidator) validate(ctx context.Context, obj runtime.Object, resolves *requestResolves) (admission.Warnings, error) {
+	var kind schema.GroupKind
+	var name string
+	var annotations map[string]string
+	var ingress, egress []calicov3.Rule
+	switch policy := obj.(type) {
+	case *calicov3.NetworkPolicy:
+		kind = calicov3.SchemeGroupVersion.WithKind(calicov3.KindNetworkPolicy).GroupKind()
+		name, annotations = policy.GetName(), policy.GetAnnotations()
+		ingress, egress = policy.Spec.Ingress, policy.Spec.Egress
+	case *calicov3.GlobalNetworkPolicy:
+		kind = calicov3.SchemeGroupVersion.WithKind(calicov3.KindGlobalNetworkPolicy).GroupKind()
+		name, annotations = policy.GetName(), policy.GetAnnotations()
+		ingress, egress = policy.Spec.Ingress, policy.Spec.Egress
+	default:
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	var allowlist domainAllowlist
+	if policy, ok := obj.(*calicov3.NetworkPolicy); ok {
+		allowlist = v.policyAllowlist(ctx, policy)
+	}
+	seen := map[selector.Term]bool{}
+	names := map[string]selector.Term{}
+	for _, ruleSelector := range ruleSelectorPaths(ingress, egress) {
+		terms, err := selector.Parse(ruleSelector.value)
+		if err != nil {
+			// the selectors without the resolver labels are validated by Calico
+			if key, ok := v.resolverKey(ruleSelector.value); ok {
+				allErrs = append(allErrs, field.Invalid(ruleSelector.path, ruleSelector.value,
+					fmt.Sprintf("selector with resolver label %s can not be parsed: %s", key, err)))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("%s: selector can not be parsed by the controller: %s", ruleSelector.path, err))
+			}
+			continue
+		}
+		for _, term := range terms {
+			term.Negated = false
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+			if _, ok := v.Resolvers.Get(term.Key); !ok {
+				if strings.HasSuffix(term.Key, resolverKeySuffix) {
+					warnings = append(warnings, fmt.Sprintf("%s: unknown resolver label %s", ruleSelector.path, term.Key))
+				}
+				continue
+			}
+			for _, msg := range validation.IsValidLabelValue(term.Value) {
+				allErrs = append(allErrs, field.Invalid(ruleSelector.path, ruleSelector.value,
+					fmt.Sprintf("%s value %q can not be used as networkset label: %s", term.Key, term.Value, msg)))
+			}
+			setName := networkSetName(name, term.Key, term.Value)
+			for _, msg := range validation.IsDNS1123Subdomain(setName) {
+				allErrs = append(allErrs, field.Invalid(ruleSelector.path, ruleSelector.value,
+					fmt.Sprintf("invalid networkset name %q: %s", setName, msg)))
+			}
+			if other, ok := names[setName]; ok {
+				allErrs = append(allErrs, field.Invalid(ruleSelector.path, ruleSelector.value,
+					fmt.Sprintf("%s == '%s' and %s == '%s' have the same networkset name %q", other.Key, other.Value, term.Key, term.Value, setName)))
+			}
+			names[setName] = term
+			if !allowlist.allows(term.Key, term.Value) {
+				warnings = append(warnings, fmt.Sprintf("%s: %s == '%s' is not allowed in the namespace, its networkset is not created",
+					ruleSelector.path, term.Key, term.Value))
+				continue
+			}
+			if warning := v.resolve(resolves, term, getAddressFamily(annotations, v.Settings.Load().AddressFamily)); warning != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", ruleSelector.path, warning))
+			}
+		}
+	}
+	if len(allErrs) > 0 {
+		return warnings, apierrors.NewInvalid(kind, name, allErrs)
+	}
+
+	return warnings, nil
+}
+
+// prepopulate creates networksets of the terms which are not in the old policy, the networksets existing already are kept.
+// The networksets are created even when the domains are not resolved and are resolved again by the controller
+func (v *PolicyValidator) prepopulate(ctx context.Context, namespace string, oldObj runtime.Object, obj runtime.Object, resolves *requestResolves) error {
+	now := time.Now()
+	settings := v.Settings.Load()
+	existing := v.policyTerms(oldObj)
+	switch policy := obj.(type) {
+	case *calicov3.NetworkPolicy:
+		// the uid is set by the api server before the validating webhooks are called
+		if policy.GetUID() == "" {
+			policyWebhookLog.Info("policy has no uid, networksets are created by the controller", "name", policy.GetName())
+			return nil
+		}
+		if policy.GetNamespace() == "" {
+			policy.SetNamespace(namespace)
+		}
+		if !settings.Namespaces.Allowed(policy.GetNamespace()) {
+			return nil
+		}
+		allowlist, err := namespaceAllowlist(ctx, v.Client, policy.GetNamespace(), settings.RequireAllowedDomains)
+		if err != nil {
+			return err
+		}
+		family := getAddressFamily(policy.GetAnnotations(), settings.AddressFamily)
+		for _, term := range resolverTerms(policyWebhookLog, v.Resolvers, ruleSelectors(policy.Spec.Ingress, policy.Spec.Egress)) {
+			if existing[term] || !allowlist.allows(term.Key, term.Value) {
+				continue
+			}
+			ipAddress, resolveErr := resolveNetworks(resolves, term, family)
+			networkSet := newNetworkset(policy, term.Key, term.Value, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			// the policy is not stored yet, the garbage collector would delete the networkset owned by its uid,
+			// the controller adds the owner reference when it adopts the networkset
+			networkSet.SetOwnerReferences(nil)
+			setResolveStatus(networkSet.GetAnnotations(), v.Resolvers.Source(term.Key, term.Value), len(ipAddress), resolveErr, true, 0, now)
+			policyWebhookLog.Info("Create networkset", "namespace", networkSet.GetNamespace(), "name", networkSet.GetName())
+			err := v.Client.Create(ctx, networkSet)
+			if client.IgnoreAlreadyExists(err) != nil {
+				monitoring.OperationFailed(monitoring.KindNetworkSet, monitoring.OperationCreate)
+				return err
+			}
+			if err == nil {
+				monitoring.OperationSucceeded(monitoring.KindNetworkSet, monitoring.OperationCreate)
+			}
+		}
+	case *calicov3.GlobalNetworkPolicy:
+		if policy.GetUID() == "" {
+			policyWebhookLog.Info("policy has no uid, globalnetworksets are created by the controller", "name", policy.GetName())
+			return nil
+		}
+		family := getAddressFamily(policy.GetAnnotations(), settings.AddressFamily)
+		for _, term := range resolverTerms(policyWebhookLog, v.Resolvers, ruleSelectors(policy.Spec.Ingress, policy.Spec.Egress)) {
+			if existing[term] {
+				continue
+			}
+			ipAddress, resolveErr := resolveNetworks(resolves, term, family)
+			globalNetworkSet := newGlobalNetworkset(policy, term.Key, term.Value, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			globalNetworkSet.SetOwnerReferences(nil)
+			setResolveStatus(globalNetworkSet.GetAnnotations(), v.Resolvers.Source(term.Key, term.Value), len(ipAddress), resolveErr, true, 0, now)
+			policyWebhookLog.Info("Create globalnetworkset", "name", globalNetworkSet.GetName())
+			err := v.Client.Create(ctx, globalNetworkSet)
+			if client.IgnoreAlreadyExists(err) != nil {
+				monitoring.OperationFailed(monitoring.KindGlobalNetworkSet, monitoring.OperationCreate)
+				return err
+			}
+			if err == nil {
+				monitoring.OperationSucceeded(monitoring.KindGlobalNetworkSet, monitoring.OperationCreate)
+			}
+		}
+	}
+	return nil
+}
+
+// policyTerms returns the resolver terms of the policy, the networksets of these terms are created already
+func (v *PolicyValidator) policyTerms(obj runtime.Object) map[selector.Term]bool {
+	var selectors []string
+	switch policy := obj.(type) {
+	case *calicov3.NetworkPolicy:
+		selectors = ruleSelectors(policy.Spec.Ingress, policy.Spec.Egress)
+	case *calicov3.GlobalNetworkPolicy:
+		selectors = ruleSelectors(policy.Spec.Ingress, policy.Spec.Egress)
+	}
+	terms := map[selector.Term]bool{}
+	for _, term := range resolverTerms(policyWebhookLog, v.Resolvers, selectors) {
+		terms[term] = true
+	}
+	return terms
+}
+
+// resolverKey returns the registered resolver label found in the selector text
+func (v *PolicyValidator) resolverKey(selectorText string) (string, bool) {
+	for _, key := range v.Resolvers.Keys() {
+		if strings.Contains(selectorText, key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// policyAllowlist returns the allowlist of the namespace of the policy. The allowlist is enforced by the controller,
+// the webhook only warns, so the policy is not rejected when the namespace can not be read
+func (v *PolicyValidator) policyAllowlist(ctx context.Context, policy *calicov3.NetworkPolicy) domainAllowlist {
+	namespace := policy.GetNamespace()
+	if namespace == "" {
+		if req, err := admission.RequestFromContext(ctx); err == nil {
+			namespace = req.Namespace
+		}
+	}
+	allowlist, err := namespaceAllowlist(ctx, v.Client, namespace, v.Settings.Load().RequireAllowedDomains)
+	if err != nil {
+		policyWebhookLog.Error(err, "cannot get allowed domains", "namespace", namespace, "name", policy.GetName())
+		return domainAllowlist{}
+	}
+	return allowlist
+}
+
+// requestResolves resolves the terms of one admission request. Validation and prepopulation share the results,
+// so every term is resolved once, the failed resolves included, and all terms are resolved within one deadline
+type requestResolves struct {
+	ctx       context.Context
+	resolvers *resolver.Registry
+	results   map[selector.Term]resolveResult
+}
+
+// resolveResult is the result of resolving the term
+type resolveResult struct {
+	prefixes []netip.Prefix
+	err      error
+}
+
+// newRequestResolves creates the resolves of the request limited by ResolveTimeout and the deadline of the request
+func (v *PolicyValidator) newRequestResolves(ctx context.Context) (*requestResolves, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if v.ResolveTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, v.ResolveTimeout)
+	}
+	return &requestResolves{ctx: ctx, resolvers: v.Resolvers, results: map[selector.Term]resolveResult{}}, cancel
+}
+
+// resolve returns the result of the term resolved earlier in the request or resolves it
+func (r *requestResolves) resolve(term selector.Term) ([]netip.Prefix, error) {
+	term.Negated = false
+	result, ok := r.results[term]
+	if !ok {
+		result.prefixes, result.err = r.resolvers.Resolve(r.ctx, term.Key, term.Value)
+		r.results[term] = result
+	}
+	return result.prefixes, result.err
+}
+
+// resolveNetworks resolves the term to the networks of the address family
+func resolveNetworks(resolves *requestResolves, term selector.Term, family resolver.AddressFamily) ([]string, error) {
+	prefixes, err := resolves.resolve(term)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.FormatPrefixes(family.Filter(prefixes)), nil
+}
+
+// resolve returns the warning when the term is resolved to nothing
+func (v *PolicyValidator) resolve(resolves *requestResolves, term selector.Term, family resolver.AddressFamily) string {
+	if v.ResolveTimeout <= 0 {
+		return ""
+	}
+	prefixes, err := resolves.resolve(term)
+	if err != nil {
+		policyWebhookLog.Info("cannot resolve domain", "label", term.Key, "domain", term.Value, "error", err.Error())
+		return fmt.Sprintf("%s == '%s' is not resolved: %s", term.Key, term.Value, err)
+	}
+	if len(prefixes) == 0 {
+		return fmt.Sprintf("%s == '%s' is resolved to no addresses", term.Key, term.Value)
+	}
+	if len(family.Filter(prefixes)) == 0 {
+		return fmt.Sprintf("%s == '%s' is resolved to no %s addresses", term.Key, term.Value, family)
+	}
+	return ""
+}
+
+// selectorPath is the selector of the rule with its field path
+type selectorPath struct {
+	path  *field.Path
+	value string
+}
+
+// ruleSelectorPaths returns source and destination selectors and not-selectors of the ingress and egress rules
+func ruleSelectorPaths(ingress []calicov3.Rule, egress []calicov3.Rule) []selectorPath {
+	var selectors []selectorPath
+	spec := field.NewPath("spec")
+	for _, direction := range []struct {
+		path  *field.Path
+		rules []calicov3.Rule
+	}{
+		{spec.Child("ingress"), ingress},
+		{spec.Child("egress"), egress},
+	} {
+		for i, rule := range direction.rules {
+			path := direction.path.Index(i)
+			for _, ruleSelector := range []selectorPath{
+				{path.Child("source", "selector"), rule.Source.Selector},
+				{path.Child("source", "notSelector"), rule.Source.NotSelector},
+				{path.Child("destination", "selector"), rule.Destination.Selector},
+				{path.Child("destination", "notSelector"), rule.Destination.NotSelector},
+			} {
+				if ruleSelector.value != "" {
+					selectors = append(selectors, ruleSelector)
+				}
+			}
+		}
+	}
+	return selectors
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// countingResolver counts the resolves, the failing resolver returns the error
+type countingResolver struct {
+	resolves atomic.Int32
+	fail     bool
+}
+
+func (c *countingResolver) Resolve(ctx context.Context, key string, value string) ([]netip.Prefix, error) {
+	c.resolves.Add(1)
+	if c.fail {
+		return nil, errors.New("SERVFAIL")
+	}
+	return []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}, nil
+}
+
+func TestPolicyValidatorResolvesOnce(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := calicov3.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	rules := []calicov3.Rule{
+		{Action: calicov3.Allow, Destination: calicov3.EntityRule{Selector: "TEST_RESOLVER == 'example.com'"}},
+		{Action: calicov3.Allow, Destination: calicov3.EntityRule{NotSelector: "TEST_RESOLVER == 'example.com'"}},
+	}
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Namespace: "default", Name: "policy"}})
+
+	for _, fail := range []bool{false, true} {
+		counting := &countingResolver{fail: fail}
+		resolvers := resolver.NewRegistry()
+		resolvers.Register("TEST_RESOLVER", counting)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace).Build()
+		v := &PolicyValidator{
+			Client:         c,
+			Resolvers:      resolvers,
+			Settings:       NewSettingsStore(Settings{}),
+			ResolveTimeout: time.Second,
+			Prepopulate:    true,
+		}
+		policy := &calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy", UID: "uid"},
+			Spec: calicov3.NetworkPolicySpec{Egress: rules}}
+
+		if _, err := v.ValidateCreate(ctx, policy); err != nil {
+			t.Fatalf("failed %v: policy is rejected: %v", fail, err)
+		}
+		if resolves := counting.resolves.Load(); resolves != 1 {
+			t.Errorf("failed %v: domain is resolved %d times, expected once", fail, resolves)
+		}
+		networkSet := &calicov3.NetworkSet{}
+		key := client.ObjectKey{Namespace: "default", Name: networkSetName("policy", "TEST_RESOLVER", "example.com")}
+		if err := c.Get(context.Background(), key, networkSet); err != nil {
+			t.Fatalf("failed %v: networkset is not created: %v", fail, err)
+		}
+		if refs := networkSet.GetOwnerReferences(); len(refs) != 0 {
+			t.Errorf("failed %v: prepopulated networkset has owner references %v", fail, refs)
+		}
+	}
+}
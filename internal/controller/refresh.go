@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// refreshAnnotation requests the immediate resolve of the networkset bypassing the cache,
+// the value is the time of the request. The annotation is removed when the networkset is resolved
+var refreshAnnotation = annotationPrefix + "refresh"
+
+// defaultRefreshInterval is used when the refresh intervals are not set
+const defaultRefreshInterval = 5 * time.Second
+
+// refreshState is the schedule of a single networkset
+type refreshState struct {
+	next     time.Time
+	failures int
+}
+
+// refreshSchedule keeps the time of the next refresh and the number of failed resolves for each networkset
+type refreshSchedule struct {
+	mu     sync.Mutex
+	states map[string]refreshState
+}
+
+// remaining returns the time left before the networkset should be refreshed, zero if it is due
+func (s *refreshSchedule) remaining(name string, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[name]
+	if !ok || !now.Before(state.next) {
+		return 0
+	}
+	return state.next.Sub(now)
+}
+
+// succeeded schedules the next refresh of the networkset and resets its failures
+func (s *refreshSchedule) succeeded(name string, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[string]refreshState{}
+	}
+	s.states[name] = refreshState{next: next}
+}
+
+// failed schedules the retry of the networkset with exponential backoff from floor to ceiling
+func (s *refreshSchedule) failed(name string, now time.Time, floor time.Duration, ceiling time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[string]refreshState{}
+	}
+	state := s.states[name]
+	backoff := refreshInterval(0, floor, ceiling)
+	for i := 0; i < state.failures && (ceiling <= 0 || backoff < ceiling); i++ {
+		backoff *= 2
+	}
+	if ceiling > 0 && backoff > ceiling {
+		backoff = ceiling
+	}
+	state.failures++
+	state.next = now.Add(backoff)
+	s.states[name] = state
+	return backoff
+}
+
+// forget removes the deleted networkset from the schedule
+func (s *refreshSchedule) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, name)
+}
+
+// refreshInterval clamps the TTL of the resolved records between floor and ceiling
+func refreshInterval(ttl time.Duration, floor time.Duration, ceiling time.Duration) time.Duration {
+	if floor <= 0 {
+		floor = defaultRefreshInterval
+	}
+	if ttl < floor {
+		return floor
+	}
+	if ceiling > 0 && ttl > ceiling {
+		return ceiling
+	}
+	return ttl
+}
+
+// refreshRequested reports whether the annotations request the immediate resolve
+func refreshRequested(annotations map[string]string) bool {
+	_, ok := annotations[refreshAnnotation]
+	return ok
+}
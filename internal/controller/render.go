@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var renderLog = ctrl.Log.WithName("render")
+
+// RenderNetworkSets returns the networksets the controller creates for the NetworkPolicy or GlobalNetworkPolicy.
+// The networksets of the domains which are not resolved are rendered like the controller creates them,
+// with the failure annotations, and the resolve errors are returned joined.
+// The policy read from the file has no uid, so its networksets have no owner reference and uid label,
+// the controller adopts them when it reconciles the policy and keeps them without these fields
+func RenderNetworkSets(ctx context.Context, resolvers *resolver.Registry, settings Settings, policy client.Object, now time.Time) ([]client.Object, error) {
+	var networkSets []client.Object
+	var errs []error
+	resolve := func(label string, domain string, family resolver.AddressFamily) ([]string, error) {
+		prefixes, err := resolvers.Resolve(ctx, label, domain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: cannot resolve %s == '%s': %w",
+				policy.GetObjectKind().GroupVersionKind().Kind, policy.GetName(), label, domain, err))
+			return nil, err
+		}
+		return resolver.FormatPrefixes(family.Filter(prefixes)), nil
+	}
+
+	switch instance := policy.(type) {
+	case *calicov3.NetworkPolicy:
+		family := getAddressFamily(instance.GetAnnotations(), settings.AddressFamily)
+		for _, term := range resolverTerms(renderLog, resolvers, ruleSelectors(instance.Spec.Ingress, instance.Spec.Egress)) {
+			ipAddress, resolveErr := resolve(term.Key, term.Value, family)
+			networkSet := newNetworkset(instance, term.Key, term.Value, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			setResolveStatus(networkSet.GetAnnotations(), resolvers.Source(term.Key, term.Value), len(ipAddress), resolveErr, true, 0, now)
+			networkSets = append(networkSets, networkSet)
+		}
+	case *calicov3.GlobalNetworkPolicy:
+		family := getAddressFamily(instance.GetAnnotations(), settings.AddressFamily)
+		for _, term := range resolverTerms(renderLog, resolvers, ruleSelectors(instance.Spec.Ingress, instance.Spec.Egress)) {
+			ipAddress, resolveErr := resolve(term.Key, term.Value, family)
+			globalNetworkSet := newGlobalNetworkset(instance, term.Key, term.Value, ipAddress, resolveErr, family, settings.FailurePolicy, now)
+			setResolveStatus(globalNetworkSet.GetAnnotations(), resolvers.Source(term.Key, term.Value), len(ipAddress), resolveErr, true, 0, now)
+			networkSets = append(networkSets, globalNetworkSet)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected object %T", policy)
+	}
+
+	if policy.GetUID() == "" {
+		for _, networkSet := range networkSets {
+			networkSet.SetOwnerReferences(nil)
+			labels := networkSet.GetLabels()
+			delete(labels, parentPolicyUIDLabel)
+			networkSet.SetLabels(labels)
+		}
+	}
+	return networkSets, errors.Join(errs...)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdateNetworksetAdoption(t *testing.T) {
+	policy := &calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy", UID: "uid"}}
+	rendered := createNetworkset(&calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"}},
+		"DNS_RESOLVER", "example.com", nil)
+	rendered.SetOwnerReferences(nil)
+	delete(rendered.Labels, parentPolicyUIDLabel)
+	updateNetworkset(policy, rendered, "DNS_RESOLVER", "example.com", nil)
+	if _, ok := rendered.Labels[parentPolicyUIDLabel]; ok {
+		t.Errorf("uid label is added to the rendered networkset")
+	}
+	if len(rendered.GetOwnerReferences()) != 0 {
+		t.Errorf("owner reference is added to the rendered networkset: %v", rendered.GetOwnerReferences())
+	}
+
+	created := createNetworkset(&calicov3.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy", UID: "old-uid"}},
+		"DNS_RESOLVER", "example.com", nil)
+	updateNetworkset(policy, created, "DNS_RESOLVER", "example.com", nil)
+	if uid := created.Labels[parentPolicyUIDLabel]; uid != "uid" {
+		t.Errorf("uid label is %q, expected uid", uid)
+	}
+	if ref := metav1.GetControllerOf(created); ref == nil || ref.UID != "uid" {
+		t.Errorf("controller reference is %v, expected uid", ref)
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/javdet/networksets-controller/internal/resolver"
+	"github.com/javdet/networksets-controller/internal/selector"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+)
+
+// ruleSelectors returns unique source and destination selectors and not-selectors of the ingress and egress rules
+func ruleSelectors(ingress []calicov3.Rule, egress []calicov3.Rule) []string {
+	var selectors []string
+	seen := map[string]bool{}
+	for _, rules := range [][]calicov3.Rule{ingress, egress} {
+		for _, rule := range rules {
+			for _, selector := range []string{
+				rule.Source.Selector, rule.Source.NotSelector,
+				rule.Destination.Selector, rule.Destination.NotSelector,
+			} {
+				if selector == "" || seen[selector] {
+					continue
+				}
+				seen[selector] = true
+				selectors = append(selectors, selector)
+			}
+		}
+	}
+	return selectors
+}
+
+// resolverTerms returns unique terms of the registered resolver labels found in the selectors,
+// negated terms need the same networksets, so the negation is dropped.
+// Selectors which can not be parsed are skipped
+func resolverTerms(log logr.Logger, resolvers *resolver.Registry, selectors []string) []selector.Term {
+	var terms []selector.Term
+	seen := map[selector.Term]bool{}
+	for _, expression := range selectors {
+		parsed, err := selector.Parse(expression)
+		if err != nil {
+			log.Error(err, "cannot parse selector", "selector", expression)
+			continue
+		}
+		for _, term := range parsed {
+			if _, ok := resolvers.Get(term.Key); !ok {
+				continue
+			}
+			term.Negated = false
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// isDesiredTerm reports whether the resolver label of the networkset is referenced by one of the terms
+func isDesiredTerm(resolvers *resolver.Registry, labels map[string]string, terms []selector.Term) bool {
+	key, value, ok := resolvers.Match(labels)
+	if !ok {
+		return false
+	}
+	for _, term := range terms {
+		if term.Key == key && term.Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/javdet/networksets-controller/internal/resolver"
+)
+
+// defaultMaxConcurrentReconciles is used when the reconciler has no concurrency set
+const defaultMaxConcurrentReconciles = 2
+
+// Settings are the options of the reconcilers which can be changed without restart
+type Settings struct {
+	// RefreshInterval is used for resolvers without TTL
+	RefreshInterval time.Duration
+	// MinRefreshInterval and MaxRefreshInterval bound the refresh interval set by the records TTL
+	MinRefreshInterval time.Duration
+	MaxRefreshInterval time.Duration
+	// AddressFamily is used when the policy or the networkset has no address-family annotation
+	AddressFamily resolver.AddressFamily
+	// FailurePolicy defines networks of the networkset when the domain is not resolved
+	FailurePolicy FailurePolicy
+	// Namespaces selects the namespaces of the managed policies, networksets and domainsets
+	Namespaces NamespaceFilter
+	// RequireAllowedDomains denies all domains in the namespaces without the allowed-domains annotation
+	RequireAllowedDomains bool
+}
+
+// NamespaceFilter selects namespaces by name. All namespaces are selected when Include is empty,
+// the namespaces listed in Exclude are never selected
+type NamespaceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Allowed reports whether the namespace is selected by the filter
+func (f NamespaceFilter) Allowed(namespace string) bool {
+	if slices.Contains(f.Exclude, namespace) {
+		return false
+	}
+	return len(f.Include) == 0 || slices.Contains(f.Include, namespace)
+}
+
+// SettingsStore shares the settings between the reconcilers, the settings are replaced when the configuration is reloaded
+type SettingsStore struct {
+	settings atomic.Pointer[Settings]
+}
+
+// NewSettingsStore creates the store with the initial settings
+func NewSettingsStore(settings Settings) *SettingsStore {
+	s := &SettingsStore{}
+	s.Store(settings)
+	return s
+}
+
+// Load returns the current settings
+func (s *SettingsStore) Load() Settings {
+	return *s.settings.Load()
+}
+
+// Store replaces the settings, the reconciles in progress keep the previous settings
+func (s *SettingsStore) Store(settings Settings) {
+	s.settings.Store(&settings)
+}
+
+// maxConcurrentReconciles returns the concurrency of the reconciler or the default
+func maxConcurrentReconciles(concurrency int) int {
+	if concurrency > 0 {
+		return concurrency
+	}
+	return defaultMaxConcurrentReconciles
+}
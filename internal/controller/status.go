@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// lastResolveAnnotation is the time of the last successful resolve in RFC3339 format
+	lastResolveAnnotation = annotationPrefix + "last-resolve"
+	// lastChangeAnnotation is the time of the last successful resolve which changed the networks in RFC3339 format
+	lastChangeAnnotation = annotationPrefix + "last-change"
+	// resolvedCountAnnotation is the number of networks returned by the last successful resolve
+	resolvedCountAnnotation = annotationPrefix + "resolved-count"
+	// sourceAnnotation describes where the domain is resolved, like the DNS servers or the url of the http resolver
+	sourceAnnotation = annotationPrefix + "source"
+)
+
+// Reasons of the events recorded on the policies and networksets
+const (
+	reasonNetworkSetCreated = "NetworkSetCreated"
+	reasonNetworkSetRemoved = "NetworkSetRemoved"
+	reasonResolveFailed     = "ResolveFailed"
+	reasonResolved          = "Resolved"
+	reasonRefreshed         = "Refreshed"
+)
+
+// setResolveStatus records the source and the result of the resolve in the networkset annotations,
+// the error of the failed resolve is recorded by FailurePolicy. The time of the last change is recorded when
+// the networks or the error state are changed. The time of the resolve is the real time of the last successful
+// resolve, it is refreshed when it is older than the refresh interval, so the unchanged networkset is updated
+// at most once per interval
+func setResolveStatus(annotations map[string]string, source string, resolved int, resolveErr error, changed bool, interval time.Duration, now time.Time) {
+	annotations[sourceAnnotation] = source
+	if resolveErr != nil {
+		return
+	}
+	count := strconv.Itoa(resolved)
+	changed = changed || annotations[resolvedCountAnnotation] != count
+	timestamp := now.UTC().Format(time.RFC3339)
+	if _, ok := annotations[lastChangeAnnotation]; !ok || changed {
+		annotations[lastChangeAnnotation] = timestamp
+	}
+	last, err := time.Parse(time.RFC3339, annotations[lastResolveAnnotation])
+	if err != nil || changed || now.Sub(last) >= interval {
+		annotations[lastResolveAnnotation] = timestamp
+	}
+	annotations[resolvedCountAnnotation] = count
+}
+
+// resolveFailed reports whether the annotations record the failed resolve
+func resolveFailed(annotations map[string]string) bool {
+	_, ok := annotations[resolveFailedSinceAnnotation]
+	return ok
+}
+
+// RemoveResolveStatus removes the source, the time and the result of the last resolve from the networkset annotations,
+// the rendered networksets do not change on every render then
+func RemoveResolveStatus(networkSet metav1.Object) {
+	annotations := networkSet.GetAnnotations()
+	delete(annotations, sourceAnnotation)
+	delete(annotations, lastResolveAnnotation)
+	delete(annotations, lastChangeAnnotation)
+	delete(annotations, resolvedCountAnnotation)
+	networkSet.SetAnnotations(annotations)
+}
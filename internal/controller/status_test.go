@@ -0,0 +1,54 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetResolveStatus(t *testing.T) {
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := created.Add(time.Minute)
+	interval := 5 * time.Minute
+	for _, tc := range []struct {
+		name         string
+		resolved     int
+		resolveErr   error
+		changed      bool
+		now          time.Time
+		expectedLast time.Time
+		expectedDiff time.Time
+	}{
+		{name: "unchanged", resolved: 2, now: later, expectedLast: created, expectedDiff: created},
+		{name: "unchanged after interval", resolved: 2, now: created.Add(interval), expectedLast: created.Add(interval), expectedDiff: created},
+		{name: "networks changed", resolved: 2, changed: true, now: later, expectedLast: later, expectedDiff: later},
+		{name: "count changed", resolved: 3, now: later, expectedLast: later, expectedDiff: later},
+		{name: "failed", resolveErr: errors.New("timeout"), changed: true, now: later, expectedLast: created, expectedDiff: created},
+	} {
+		annotations := map[string]string{}
+		setResolveStatus(annotations, "dns", 2, nil, true, interval, created)
+		setResolveStatus(annotations, "dns", tc.resolved, tc.resolveErr, tc.changed, interval, tc.now)
+		if last := annotations[lastResolveAnnotation]; last != tc.expectedLast.Format(time.RFC3339) {
+			t.Errorf("%s: last resolve is %s, expected %s", tc.name, last, tc.expectedLast.Format(time.RFC3339))
+		}
+		if last := annotations[lastChangeAnnotation]; last != tc.expectedDiff.Format(time.RFC3339) {
+			t.Errorf("%s: last change is %s, expected %s", tc.name, last, tc.expectedDiff.Format(time.RFC3339))
+		}
+	}
+}
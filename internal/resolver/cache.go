@@ -0,0 +1,161 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/javdet/networksets-controller/monitoring"
+)
+
+// Cache keeps resolved values shared by all networksets until their TTL expires.
+// Concurrent resolves of the same value wait for the single resolve in progress.
+// Failed resolves are not cached.
+type Cache struct {
+	// MinTTL is the minimal time the result is kept, shorter TTLs are raised to it
+	MinTTL time.Duration
+	// DefaultTTL is used for the resolvers without TTL
+	DefaultTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+type cacheKey struct {
+	key   string
+	value string
+}
+
+type cacheEntry struct {
+	// done is closed when the resolve is finished
+	done     chan struct{}
+	prefixes []netip.Prefix
+	expires  time.Time
+	err      error
+}
+
+// NewCache creates empty cache
+func NewCache(minTTL time.Duration, defaultTTL time.Duration) *Cache {
+	return &Cache{
+		MinTTL:     minTTL,
+		DefaultTTL: defaultTTL,
+		entries:    map[cacheKey]*cacheEntry{},
+	}
+}
+
+type resolveFunc func(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error)
+
+// resolve returns cached networks and the time left before they expire,
+// the value is resolved by the resolve function when it is not cached
+func (c *Cache) resolve(ctx context.Context, key string, value string, resolve resolveFunc) ([]netip.Prefix, time.Duration, error) {
+	id := cacheKey{key: key, value: value}
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[id]; ok {
+		select {
+		case <-entry.done:
+			if now.Before(entry.expires) {
+				c.mu.Unlock()
+				monitoring.NetworksetControllerResolveCacheHits.Inc()
+				return slices.Clone(entry.prefixes), entry.expires.Sub(now), nil
+			}
+		default:
+			c.mu.Unlock()
+			monitoring.NetworksetControllerResolveCacheHits.Inc()
+			return entry.wait(ctx)
+		}
+	}
+	monitoring.NetworksetControllerResolveCacheMisses.Inc()
+	c.prune(now)
+	entry := &cacheEntry{done: make(chan struct{})}
+	c.entries[id] = entry
+	c.mu.Unlock()
+
+	prefixes, ttl, err := resolve(ctx, key, value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		entry.err = err
+		if c.entries[id] == entry {
+			delete(c.entries, id)
+		}
+	} else {
+		if ttl == 0 {
+			ttl = c.DefaultTTL
+		}
+		if ttl < c.MinTTL {
+			ttl = c.MinTTL
+		}
+		entry.prefixes = prefixes
+		entry.expires = time.Now().Add(ttl)
+	}
+	close(entry.done)
+	monitoring.NetworksetControllerResolveCacheEntries.Set(float64(len(c.entries)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return slices.Clone(prefixes), ttl, nil
+}
+
+// forget removes the resolved value, the next resolve of the value is not served from the cache.
+// The resolve in progress is kept
+func (c *Cache) forget(key string, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := cacheKey{key: key, value: value}
+	if entry, ok := c.entries[id]; ok {
+		select {
+		case <-entry.done:
+			delete(c.entries, id)
+		default:
+		}
+	}
+	monitoring.NetworksetControllerResolveCacheEntries.Set(float64(len(c.entries)))
+}
+
+// prune removes expired values of the domains which are not used anymore, the lock must be held
+func (c *Cache) prune(now time.Time) {
+	for id, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if !now.Before(entry.expires) {
+				delete(c.entries, id)
+			}
+		default:
+		}
+	}
+}
+
+// wait returns the result of the resolve in progress
+func (e *cacheEntry) wait(ctx context.Context) ([]netip.Prefix, time.Duration, error) {
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	if e.err != nil {
+		return nil, 0, e.err
+	}
+	return slices.Clone(e.prefixes), time.Until(e.expires), nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheSingleResolve(t *testing.T) {
+	cache := NewCache(0, time.Minute)
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	resolve := func(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}, 0, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]netip.Prefix, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			prefixes, _, err := cache.resolve(context.Background(), DNSKey, "example.com", resolve)
+			if err != nil {
+				t.Errorf("cannot resolve: %v", err)
+			}
+			results[i] = prefixes
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("value is resolved %d times, expected once", n)
+	}
+	for i, prefixes := range results {
+		if len(prefixes) != 1 || prefixes[0].String() != "192.0.2.1/32" {
+			t.Errorf("resolve %d returned %v", i, prefixes)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	var calls atomic.Int32
+	resolve := func(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error) {
+		calls.Add(1)
+		return []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}, 20 * time.Millisecond, nil
+	}
+	failing := func(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error) {
+		calls.Add(1)
+		return nil, 0, errors.New("timeout")
+	}
+	for _, tc := range []struct {
+		name    string
+		minTTL  time.Duration
+		resolve resolveFunc
+		wait    time.Duration
+		forget  bool
+		calls   int32
+	}{
+		{name: "cached", resolve: resolve, calls: 1},
+		{name: "expired", resolve: resolve, wait: 50 * time.Millisecond, calls: 2},
+		{name: "raised to min TTL", minTTL: time.Minute, resolve: resolve, wait: 50 * time.Millisecond, calls: 1},
+		{name: "forgotten", resolve: resolve, forget: true, calls: 2},
+		{name: "failure is not cached", resolve: failing, calls: 2},
+	} {
+		calls.Store(0)
+		cache := NewCache(tc.minTTL, time.Minute)
+		_, _, _ = cache.resolve(context.Background(), DNSKey, "example.com", tc.resolve)
+		time.Sleep(tc.wait)
+		if tc.forget {
+			cache.forget(DNSKey, "example.com")
+		}
+		_, _, _ = cache.resolve(context.Background(), DNSKey, "example.com", tc.resolve)
+		if n := calls.Load(); n != tc.calls {
+			t.Errorf("%s: value is resolved %d times, expected %d", tc.name, n, tc.calls)
+		}
+	}
+}
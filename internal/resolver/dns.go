@@ -0,0 +1,297 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultResolvConf is the file with upstream DNS servers used when no servers are configured
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// maxUDPSize is the advertised EDNS0 UDP payload size
+const maxUDPSize = 1232
+
+// DNSResolver resolves domain names by the upstream DNS servers and keeps the records TTL
+type DNSResolver struct {
+	// Servers is a list of upstream servers in host:port format, servers are queried in order
+	Servers []string
+	Timeout time.Duration
+}
+
+// NewDNSResolver creates resolver for the upstream servers, the port 53 is used if not set
+func NewDNSResolver(servers []string) *DNSResolver {
+	upstreams := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		upstreams = append(upstreams, server)
+	}
+	return &DNSResolver{
+		Servers: upstreams,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// ReadResolvConf returns nameservers from the resolv.conf file
+func ReadResolvConf(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameservers in %s", path)
+	}
+
+	return servers, nil
+}
+
+// Resolve looks up A and AAAA records of the domain
+func (d *DNSResolver) Resolve(ctx context.Context, key string, value string) ([]netip.Prefix, error) {
+	prefixes, _, err := d.ResolveTTL(ctx, key, value)
+	return prefixes, err
+}
+
+// ResolveTTL looks up A and AAAA records of the domain and returns the shortest TTL of the answers
+func (d *DNSResolver) ResolveTTL(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error) {
+	name, err := dnsmessage.NewName(fqdn(value))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the addresses of the family which is resolved are returned when the query of the other family fails
+	var prefixes []netip.Prefix
+	var ttl uint32
+	var errs []error
+	found := false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, answerTTL, err := d.query(ctx, name, qtype)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		if !found || answerTTL < ttl {
+			ttl = answerTTL
+		}
+		found = true
+		prefixes = append(prefixes, answers...)
+	}
+	if !found {
+		if len(errs) > 0 {
+			return nil, 0, errors.Join(errs...)
+		}
+		return nil, 0, fmt.Errorf("no A or AAAA records for %s", value)
+	}
+
+	return prefixes, time.Duration(ttl) * time.Second, nil
+}
+
+// Source returns the absolute domain name and the upstream servers it is resolved by
+func (d *DNSResolver) Source(key string, value string) string {
+	return fmt.Sprintf("dns %s @%s", fqdn(value), strings.Join(d.Servers, ","))
+}
+
+// query sends the question to the servers in order until one of them answers
+func (d *DNSResolver) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Prefix, uint32, error) {
+	if len(d.Servers) == 0 {
+		return nil, 0, errors.New("no upstream DNS servers")
+	}
+	var lastErr error
+	for _, server := range d.Servers {
+		msg, err := d.exchange(ctx, server, name, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+			prefixes, ttl := parseAnswers(msg.Answers)
+			return prefixes, ttl, nil
+		case dnsmessage.RCodeNameError:
+			return nil, 0, fmt.Errorf("%s: NXDOMAIN", name)
+		default:
+			lastErr = fmt.Errorf("%s: %s from %s", name, msg.RCode, server)
+		}
+	}
+
+	return nil, 0, lastErr
+}
+
+// exchange sends the question over UDP and retries over TCP when the answer is truncated
+func (d *DNSResolver) exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	id := uint16(rand.Uint32())
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := d.exchangeConn(ctx, "udp", server, id, query)
+	if err == nil && msg.Truncated {
+		msg, err = d.exchangeConn(ctx, "tcp", server, id, query)
+	}
+	return msg, err
+}
+
+func (d *DNSResolver) exchangeConn(ctx context.Context, network string, server string, id uint16, query []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if network == "tcp" {
+		// DNS over TCP messages are prefixed with two bytes length
+		request := make([]byte, 2, len(query)+2)
+		binary.BigEndian.PutUint16(request, uint16(len(query)))
+		if _, err := conn.Write(append(request, query...)); err != nil {
+			return nil, err
+		}
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, response); err != nil {
+			return nil, err
+		}
+		msg, err := unpackResponse(response, id)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected DNS response from %s: %w", server, err)
+		}
+		return msg, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	// the datagrams which are not the answer of the query, like late answers of the previous queries,
+	// are skipped until the answer is read or the deadline is reached
+	response := make([]byte, maxUDPSize)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return nil, err
+		}
+		if msg, err := unpackResponse(response[:n], id); err == nil {
+			return msg, nil
+		}
+	}
+}
+
+// unpackResponse parses the DNS response and checks that it is the answer of the query with the id
+func unpackResponse(response []byte, id uint16) (*dnsmessage.Message, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		return nil, err
+	}
+	if msg.ID != id || !msg.Response {
+		return nil, fmt.Errorf("response id %d does not match query id %d", msg.ID, id)
+	}
+	return &msg, nil
+}
+
+// parseAnswers returns the addresses and the shortest TTL of the answer chain including CNAME records
+func parseAnswers(answers []dnsmessage.Resource) ([]netip.Prefix, uint32) {
+	var prefixes []netip.Prefix
+	var ttl uint32
+	found := false
+	for _, answer := range answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			prefixes = append(prefixes, hostPrefix(netip.AddrFrom4(body.A)))
+		case *dnsmessage.AAAAResource:
+			prefixes = append(prefixes, hostPrefix(netip.AddrFrom16(body.AAAA)))
+		case *dnsmessage.CNAMEResource:
+		default:
+			continue
+		}
+		if !found || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+		found = true
+	}
+	if len(prefixes) == 0 {
+		return nil, 0
+	}
+
+	return prefixes, ttl
+}
+
+// fqdn makes the domain absolute, search domains of resolv.conf are not used
+func fqdn(domain string) string {
+	if strings.HasSuffix(domain, ".") {
+		return domain
+	}
+	return domain + "."
+}
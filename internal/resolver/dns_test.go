@@ -0,0 +1,275 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseAnswers(t *testing.T) {
+	cname := func(ttl uint32) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeCNAME, TTL: ttl},
+			Body:   &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("target.example.com.")},
+		}
+	}
+	a := func(ttl uint32, addr string) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: netip.MustParseAddr(addr).As4()},
+		}
+	}
+	aaaa := func(ttl uint32, addr string) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA, TTL: ttl},
+			Body:   &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr(addr).As16()},
+		}
+	}
+	for _, tc := range []struct {
+		name     string
+		answers  []dnsmessage.Resource
+		prefixes []string
+		ttl      uint32
+	}{
+		{name: "no answers"},
+		{name: "A records", answers: []dnsmessage.Resource{a(300, "192.0.2.1"), a(60, "192.0.2.2")},
+			prefixes: []string{"192.0.2.1/32", "192.0.2.2/32"}, ttl: 60},
+		{name: "AAAA record", answers: []dnsmessage.Resource{aaaa(120, "2001:db8::1")},
+			prefixes: []string{"2001:db8::1/128"}, ttl: 120},
+		{name: "CNAME with shorter TTL", answers: []dnsmessage.Resource{cname(30), a(300, "192.0.2.1")},
+			prefixes: []string{"192.0.2.1/32"}, ttl: 30},
+		{name: "CNAME with longer TTL", answers: []dnsmessage.Resource{cname(3600), a(300, "192.0.2.1")},
+			prefixes: []string{"192.0.2.1/32"}, ttl: 300},
+		{name: "CNAME without addresses", answers: []dnsmessage.Resource{cname(30)}},
+	} {
+		prefixes, ttl := parseAnswers(tc.answers)
+		var formatted []string
+		for _, prefix := range prefixes {
+			formatted = append(formatted, prefix.String())
+		}
+		if !reflect.DeepEqual(formatted, tc.prefixes) || ttl != tc.ttl {
+			t.Errorf("%s: parsed %v with TTL %d, expected %v with TTL %d", tc.name, formatted, ttl, tc.prefixes, tc.ttl)
+		}
+	}
+}
+
+// answer builds the response to the query, the truncated response has no answers
+func answer(t *testing.T, query []byte, truncated bool) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Errorf("cannot unpack query: %v", err)
+		return nil
+	}
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: msg.ID, Response: true, Truncated: truncated},
+		Questions: msg.Questions,
+	}
+	if !truncated {
+		response.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}}
+	}
+	data, err := response.Pack()
+	if err != nil {
+		t.Errorf("cannot pack response: %v", err)
+	}
+	return data
+}
+
+// listenDNS listens on the same UDP and TCP port of the loopback address
+func listenDNS(t *testing.T) (net.PacketConn, net.Listener) {
+	for i := 0; i < 10; i++ {
+		udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Skipf("cannot listen on UDP: %v", err)
+		}
+		tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+		if err == nil {
+			return udp, tcp
+		}
+		udp.Close()
+	}
+	t.Skip("cannot listen on the same UDP and TCP port")
+	return nil, nil
+}
+
+func TestExchangeTCPFallback(t *testing.T) {
+	udp, tcp := listenDNS(t)
+	defer udp.Close()
+	defer tcp.Close()
+
+	go func() {
+		buf := make([]byte, maxUDPSize)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(answer(t, buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			length := make([]byte, 2)
+			if _, err := io.ReadFull(conn, length); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					response := answer(t, query, false)
+					binary.BigEndian.PutUint16(length, uint16(len(response)))
+					_, _ = conn.Write(append(length, response...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	d := NewDNSResolver([]string{udp.LocalAddr().String()})
+	d.Timeout = 5 * time.Second
+	msg, err := d.exchange(context.Background(), d.Servers[0], dnsmessage.MustNewName("example.com."), dnsmessage.TypeA)
+	if err != nil {
+		t.Fatalf("cannot exchange: %v", err)
+	}
+	if msg.Truncated {
+		t.Errorf("truncated UDP response is returned")
+	}
+	prefixes, ttl := parseAnswers(msg.Answers)
+	if len(prefixes) != 1 || prefixes[0].String() != "192.0.2.1/32" || ttl != 60 {
+		t.Errorf("resolved %v with TTL %d, expected [192.0.2.1/32] with TTL 60", prefixes, ttl)
+	}
+}
+
+// serveUDP answers the DNS queries on the loopback address by the handler, the handler returns the datagrams to send
+func serveUDP(t *testing.T, handler func(query dnsmessage.Message) []dnsmessage.Message) *DNSResolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxUDPSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			for _, response := range handler(query) {
+				data, err := response.Pack()
+				if err != nil {
+					t.Errorf("cannot pack response: %v", err)
+					continue
+				}
+				_, _ = conn.WriteTo(data, addr)
+			}
+		}
+	}()
+	d := NewDNSResolver([]string{conn.LocalAddr().String()})
+	d.Timeout = 5 * time.Second
+	return d
+}
+
+func TestResolveTTLFamilyFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rcodes   map[dnsmessage.Type]dnsmessage.RCode
+		prefixes []string
+		wantErr  bool
+	}{
+		{name: "AAAA fails", rcodes: map[dnsmessage.Type]dnsmessage.RCode{dnsmessage.TypeAAAA: dnsmessage.RCodeServerFailure},
+			prefixes: []string{"192.0.2.1/32"}},
+		{name: "A fails", rcodes: map[dnsmessage.Type]dnsmessage.RCode{dnsmessage.TypeA: dnsmessage.RCodeServerFailure},
+			prefixes: []string{"2001:db8::1/128"}},
+		{name: "both fail", rcodes: map[dnsmessage.Type]dnsmessage.RCode{
+			dnsmessage.TypeA: dnsmessage.RCodeServerFailure, dnsmessage.TypeAAAA: dnsmessage.RCodeServerFailure}, wantErr: true},
+	} {
+		d := serveUDP(t, func(query dnsmessage.Message) []dnsmessage.Message {
+			question := query.Questions[0]
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: tc.rcodes[question.Type]},
+				Questions: query.Questions,
+			}
+			if response.RCode == dnsmessage.RCodeSuccess {
+				header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+				if question.Type == dnsmessage.TypeA {
+					response.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}}
+				} else {
+					response.Answers = []dnsmessage.Resource{{Header: header,
+						Body: &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("2001:db8::1").As16()}}}
+				}
+			}
+			return []dnsmessage.Message{response}
+		})
+		prefixes, _, err := d.ResolveTTL(context.Background(), DNSKey, "example.com")
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: resolved %v, expected error", tc.name, prefixes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		var formatted []string
+		for _, prefix := range prefixes {
+			formatted = append(formatted, prefix.String())
+		}
+		if !reflect.DeepEqual(formatted, tc.prefixes) {
+			t.Errorf("%s: resolved %v, expected %v", tc.name, formatted, tc.prefixes)
+		}
+	}
+}
+
+func TestExchangeSkipsOtherResponses(t *testing.T) {
+	d := serveUDP(t, func(query dnsmessage.Message) []dnsmessage.Message {
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+			}},
+		}
+		stale := response
+		stale.ID = query.ID + 1
+		return []dnsmessage.Message{stale, response}
+	})
+	msg, err := d.exchange(context.Background(), d.Servers[0], dnsmessage.MustNewName("example.com."), dnsmessage.TypeA)
+	if err != nil {
+		t.Fatalf("cannot exchange: %v", err)
+	}
+	if prefixes, _ := parseAnswers(msg.Answers); len(prefixes) != 1 || prefixes[0].String() != "192.0.2.1/32" {
+		t.Errorf("resolved %v, expected [192.0.2.1/32]", prefixes)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AddressFamily selects IP networks of the family
+type AddressFamily string
+
+const (
+	IPv4      AddressFamily = "IPv4"
+	IPv6      AddressFamily = "IPv6"
+	DualStack AddressFamily = "Dual"
+)
+
+// ParseAddressFamily parses IPv4, IPv6 or Dual (case insensitive)
+func ParseAddressFamily(value string) (AddressFamily, error) {
+	for _, family := range []AddressFamily{IPv4, IPv6, DualStack} {
+		if strings.EqualFold(value, string(family)) {
+			return family, nil
+		}
+	}
+	return "", fmt.Errorf("unknown address family %q, expected %s, %s or %s", value, IPv4, IPv6, DualStack)
+}
+
+// Filter returns networks of the address family
+func (f AddressFamily) Filter(prefixes []netip.Prefix) []netip.Prefix {
+	if f == DualStack || f == "" {
+		return prefixes
+	}
+	filtered := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix.Addr().Unmap().Is4() == (f == IPv4) {
+			filtered = append(filtered, prefix)
+		}
+	}
+	return filtered
+}
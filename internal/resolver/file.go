@@ -0,0 +1,57 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileResolver reads IP networks/CIDRs from the file named by the selector value,
+// for example from the mounted ConfigMap
+type FileResolver struct {
+	Dir string
+}
+
+// NewFileResolver creates resolver for the directory
+func NewFileResolver(dir string) *FileResolver {
+	return &FileResolver{
+		Dir: dir,
+	}
+}
+
+// Resolve reads the address list from the file in the directory
+func (f *FileResolver) Resolve(ctx context.Context, key string, value string) ([]netip.Prefix, error) {
+	if value == "" || value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+		return nil, fmt.Errorf("invalid file name %q", value)
+	}
+	body, err := os.ReadFile(filepath.Join(f.Dir, value))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAddressList(body)
+}
+
+// Source returns the path of the file named by the selector value
+func (f *FileResolver) Source(key string, value string) string {
+	return filepath.Join(f.Dir, value)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// urlValuePlaceholder is replaced in the url template by the selector value
+const urlValuePlaceholder = "{value}"
+
+// maxResponseSize limits the size of the http resolver response body
+const maxResponseSize = 4 << 20
+
+// HTTPResolver requests IP networks/CIDRs for a selector label from the http url
+type HTTPResolver struct {
+	// URL is a template of the url, {value} is replaced by the selector value
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPResolver creates resolver for the url template
+func NewHTTPResolver(urlTemplate string) *HTTPResolver {
+	return &HTTPResolver{
+		URL:    urlTemplate,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve requests the url with the selector value
+func (h *HTTPResolver) Resolve(ctx context.Context, key string, value string) ([]netip.Prefix, error) {
+	requestURL := h.requestURL(value)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q from %s", resp.Status, requestURL)
+	}
+	// one byte over the limit is read to tell the oversized body from the body of exactly the limit
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("response from %s exceeds %d bytes", requestURL, maxResponseSize)
+	}
+
+	return ParseAddressList(body)
+}
+
+// Source returns the url requested for the selector value, the password is redacted
+func (h *HTTPResolver) Source(key string, value string) string {
+	requestURL, err := url.Parse(h.requestURL(value))
+	if err != nil {
+		return key
+	}
+	return requestURL.Redacted()
+}
+
+// requestURL replaces the placeholder in the url template by the selector value
+func (h *HTTPResolver) requestURL(value string) string {
+	return strings.ReplaceAll(h.URL, urlValuePlaceholder, url.QueryEscape(value))
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPResolverResponseSize(t *testing.T) {
+	line := "192.0.2.1/32\n"
+	for _, tc := range []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{"at limit", maxResponseSize, false},
+		{"over limit", maxResponseSize + 1, true},
+	} {
+		body := strings.Repeat(line, tc.size/len(line))
+		body += strings.Repeat(" ", tc.size-len(body))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(body))
+		}))
+		prefixes, err := NewHTTPResolver(server.URL+"/{value}").Resolve(context.Background(), "HTTP_RESOLVER", "example")
+		server.Close()
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: oversized response is accepted with %d networks", tc.name, len(prefixes))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
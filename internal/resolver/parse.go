@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"strings"
+)
+
+// ParseAddressList parses a JSON list (or an object with "addresses" field)
+// or a plain-text list of IP addresses/CIDRs separated by newlines, spaces or commas.
+// Lines starting with # are comments.
+func ParseAddressList(body []byte) ([]netip.Prefix, error) {
+	var entries []string
+	body = bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(body, []byte("[")):
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(body, []byte("{")):
+		var object struct {
+			Addresses []string `json:"addresses"`
+		}
+		if err := json.Unmarshal(body, &object); err != nil {
+			return nil, err
+		}
+		entries = object.Addresses
+	default:
+		for _, line := range strings.Split(string(body), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			entries = append(entries, strings.FieldsFunc(line, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t' || r == '\r'
+			})...)
+		}
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// ParsePrefix parses IP address or CIDR, IP address is converted to the single host network
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return hostPrefix(addr), nil
+}
+
+// FormatPrefixes converts networks to CIDR strings
+func FormatPrefixes(prefixes []netip.Prefix) []string {
+	nets := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		nets = append(nets, prefix.String())
+	}
+	return nets
+}
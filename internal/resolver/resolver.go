@@ -0,0 +1,201 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/javdet/networksets-controller/monitoring"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// DNSKey is the default selector label resolved by DNS
+const DNSKey = "DNS_RESOLVER"
+
+var resolverLog = ctrl.Log.WithName("resolver")
+
+// Resolver returns IP networks for the value of the selector label key
+type Resolver interface {
+	Resolve(ctx context.Context, key string, value string) ([]netip.Prefix, error)
+}
+
+// TTLResolver is implemented by resolvers which know how long the result is valid, like DNS
+type TTLResolver interface {
+	ResolveTTL(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error)
+}
+
+// SourceResolver is implemented by resolvers which can tell where the value is resolved, like the url of the http resolver
+type SourceResolver interface {
+	Source(key string, value string) string
+}
+
+// Registry maps selector label keys to resolvers
+type Registry struct {
+	mu        sync.RWMutex
+	resolvers map[string]Resolver
+	cache     *Cache
+	dnsKey    string
+}
+
+// NewRegistry creates empty registry, the DNS resolver is expected under DNSKey
+func NewRegistry() *Registry {
+	return &Registry{
+		resolvers: map[string]Resolver{},
+		dnsKey:    DNSKey,
+	}
+}
+
+// SetDNSKey replaces the selector label of the DNS resolver, the domains of the domainsets are resolved by this label
+func (r *Registry) SetDNSKey(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dnsKey = key
+}
+
+// DNSKey returns the selector label of the DNS resolver
+func (r *Registry) DNSKey() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.dnsKey
+}
+
+// Register adds resolver for the selector label key, existing resolver is replaced
+func (r *Registry) Register(key string, resolver Resolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolvers[key] = resolver
+}
+
+// Unregister removes resolver of the selector label key
+func (r *Registry) Unregister(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.resolvers, key)
+}
+
+// UseCache shares the results of resolves through the cache
+func (r *Registry) UseCache(cache *Cache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = cache
+}
+
+// Invalidate removes the cached result of the value, so it is resolved again by the next resolve
+func (r *Registry) Invalidate(key string, value string) {
+	r.mu.RLock()
+	cache := r.cache
+	r.mu.RUnlock()
+	if cache != nil {
+		cache.forget(key, value)
+	}
+}
+
+// Get returns resolver for the selector label key
+func (r *Registry) Get(key string) (Resolver, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resolver, ok := r.resolvers[key]
+	return resolver, ok
+}
+
+// Keys returns sorted list of registered selector label keys
+func (r *Registry) Keys() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]string, 0, len(r.resolvers))
+	for key := range r.resolvers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Match finds registered selector label key in the labels
+func (r *Registry) Match(labels map[string]string) (string, string, bool) {
+	for _, key := range r.Keys() {
+		if value, ok := labels[key]; ok {
+			return key, value, true
+		}
+	}
+	return "", "", false
+}
+
+// Source describes where the value is resolved by the resolver registered for the key,
+// the key is returned if the resolver does not implement SourceResolver
+func (r *Registry) Source(key string, value string) string {
+	resolver, ok := r.Get(key)
+	if !ok {
+		return key
+	}
+	if sourceResolver, ok := resolver.(SourceResolver); ok {
+		return sourceResolver.Source(key, value)
+	}
+	return key
+}
+
+// Resolve resolves value by the resolver registered for the key
+func (r *Registry) Resolve(ctx context.Context, key string, value string) ([]netip.Prefix, error) {
+	prefixes, _, err := r.ResolveTTL(ctx, key, value)
+	return prefixes, err
+}
+
+// ResolveTTL resolves value by the resolver registered for the key,
+// the TTL is zero if the resolver does not implement TTLResolver.
+// With the cache the TTL is the time left before the cached value expires
+func (r *Registry) ResolveTTL(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error) {
+	r.mu.RLock()
+	cache := r.cache
+	r.mu.RUnlock()
+	if cache != nil {
+		return cache.resolve(ctx, key, value, r.resolveTTL)
+	}
+	return r.resolveTTL(ctx, key, value)
+}
+
+func (r *Registry) resolveTTL(ctx context.Context, key string, value string) ([]netip.Prefix, time.Duration, error) {
+	resolver, ok := r.Get(key)
+	if !ok {
+		return nil, 0, fmt.Errorf("no resolver for label %s", key)
+	}
+	var prefixes []netip.Prefix
+	var ttl time.Duration
+	var err error
+	start := time.Now()
+	if ttlResolver, ok := resolver.(TTLResolver); ok {
+		prefixes, ttl, err = ttlResolver.ResolveTTL(ctx, key, value)
+	} else {
+		prefixes, err = resolver.Resolve(ctx, key, value)
+	}
+	monitoring.ObserveResolve(key, value, time.Since(start), err)
+	if err != nil {
+		resolverLog.Error(err, "Error resolving", "key", key, "value", value)
+		return nil, 0, err
+	}
+
+	return prefixes, ttl, nil
+}
+
+// hostPrefix converts IP address to the single host network
+func hostPrefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenLabel
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBrace
+	tokenRBrace
+	tokenComma
+	tokenEq
+	tokenNe
+	tokenNot
+	tokenAnd
+	tokenOr
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of selector"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// isLabelChar reports whether the character is allowed in the label name or keyword
+func isLabelChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '/' || c == '-'
+}
+
+// tokenize splits the selector into tokens
+func tokenize(selector string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(selector); {
+		c := selector[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(selector[pos+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", pos)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: selector[pos+1 : pos+1+end], pos: pos})
+			pos += end + 2
+		case strings.HasPrefix(selector[pos:], "=="):
+			tokens = append(tokens, token{kind: tokenEq, value: "==", pos: pos})
+			pos += 2
+		case strings.HasPrefix(selector[pos:], "!="):
+			tokens = append(tokens, token{kind: tokenNe, value: "!=", pos: pos})
+			pos += 2
+		case strings.HasPrefix(selector[pos:], "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, value: "&&", pos: pos})
+			pos += 2
+		case strings.HasPrefix(selector[pos:], "||"):
+			tokens = append(tokens, token{kind: tokenOr, value: "||", pos: pos})
+			pos += 2
+		case c == '!':
+			tokens = append(tokens, token{kind: tokenNot, value: "!", pos: pos})
+			pos++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, value: "(", pos: pos})
+			pos++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, value: ")", pos: pos})
+			pos++
+		case c == '{':
+			tokens = append(tokens, token{kind: tokenLBrace, value: "{", pos: pos})
+			pos++
+		case c == '}':
+			tokens = append(tokens, token{kind: tokenRBrace, value: "}", pos: pos})
+			pos++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, value: ",", pos: pos})
+			pos++
+		case isLabelChar(c):
+			start := pos
+			for pos < len(selector) && isLabelChar(selector[pos]) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenLabel, value: selector[start:pos], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, pos)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(selector)}), nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package selector parses Calico selector expressions and extracts label terms from them.
+//
+// The grammar follows https://docs.tigera.io/calico/latest/reference/resources/networkpolicy#selectors:
+//
+//	all()  global()  has(k)  !has(k)
+//	k == 'v'  k != 'v'  k in {'v1', 'v2'}  k not in {'v1', 'v2'}
+//	k contains 's'  k starts with 's'  k ends with 's'
+//	!expr  expr && expr  expr || expr  (expr)
+package selector
+
+import (
+	"fmt"
+)
+
+// Term is a label equality found in the selector, k in {'a', 'b'} produces a term per value
+type Term struct {
+	Key   string
+	Value string
+	// Negated is true for k != 'v', k not in {...} and terms under odd number of ! operators
+	Negated bool
+}
+
+// Parse validates the selector and returns label equality terms in the order of appearance
+func Parse(selector string) ([]Term, error) {
+	tokens, err := tokenize(selector)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind != tokenEOF {
+		if err := p.parseOr(false); err != nil {
+			return nil, err
+		}
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", next, next.pos)
+	}
+
+	return p.terms, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	terms  []Term
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("expected %s at position %d, got %s", what, t.pos, t)
+	}
+	return t, nil
+}
+
+// expectWord consumes the keyword
+func (p *parser) expectWord(word string) error {
+	t := p.next()
+	if t.kind != tokenLabel || t.value != word {
+		return fmt.Errorf("expected %q at position %d, got %s", word, t.pos, t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr(negated bool) error {
+	if err := p.parseAnd(negated); err != nil {
+		return err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		if err := p.parseAnd(negated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseAnd(negated bool) error {
+	if err := p.parseUnary(negated); err != nil {
+		return err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		if err := p.parseUnary(negated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseUnary(negated bool) error {
+	switch t := p.peek(); t.kind {
+	case tokenNot:
+		p.next()
+		return p.parseUnary(!negated)
+	case tokenLParen:
+		p.next()
+		if err := p.parseOr(negated); err != nil {
+			return err
+		}
+		_, err := p.expect(tokenRParen, "\")\"")
+		return err
+	case tokenLabel:
+		return p.parseTerm(negated)
+	default:
+		return fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+}
+
+func (p *parser) parseTerm(negated bool) error {
+	label := p.next()
+
+	// functions all(), global() and has(label)
+	if p.peek().kind == tokenLParen {
+		switch label.value {
+		case "all", "global":
+			p.next()
+			_, err := p.expect(tokenRParen, "\")\"")
+			return err
+		case "has":
+			p.next()
+			if _, err := p.expect(tokenLabel, "label"); err != nil {
+				return err
+			}
+			_, err := p.expect(tokenRParen, "\")\"")
+			return err
+		default:
+			return fmt.Errorf("unknown function %q at position %d", label.value, label.pos)
+		}
+	}
+
+	operator := p.next()
+	switch operator.kind {
+	case tokenEq, tokenNe:
+		value, err := p.expect(tokenString, "string")
+		if err != nil {
+			return err
+		}
+		p.terms = append(p.terms, Term{Key: label.value, Value: value.value, Negated: negated != (operator.kind == tokenNe)})
+		return nil
+	case tokenLabel:
+	default:
+		return fmt.Errorf("expected operator after %s at position %d, got %s", label, operator.pos, operator)
+	}
+
+	switch operator.value {
+	case "in":
+		return p.parseSet(label.value, negated)
+	case "not":
+		if err := p.expectWord("in"); err != nil {
+			return err
+		}
+		return p.parseSet(label.value, !negated)
+	case "contains":
+	case "starts", "ends":
+		if err := p.expectWord("with"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown operator %q at position %d", operator.value, operator.pos)
+	}
+	// partial matches do not reference exact values
+	_, err := p.expect(tokenString, "string")
+	return err
+}
+
+// parseSet parses {'v1', 'v2'} set of the in operator
+func (p *parser) parseSet(key string, negated bool) error {
+	if _, err := p.expect(tokenLBrace, "\"{\""); err != nil {
+		return err
+	}
+	if p.peek().kind == tokenRBrace {
+		p.next()
+		return nil
+	}
+	for {
+		value, err := p.expect(tokenString, "string")
+		if err != nil {
+			return err
+		}
+		p.terms = append(p.terms, Term{Key: key, Value: value.value, Negated: negated})
+		separator := p.next()
+		switch separator.kind {
+		case tokenComma:
+		case tokenRBrace:
+			return nil
+		default:
+			return fmt.Errorf("expected \",\" or \"}\" at position %d, got %s", separator.pos, separator)
+		}
+	}
+}
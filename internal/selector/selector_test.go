@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		selector string
+		terms    []Term
+	}{
+		{selector: "", terms: nil},
+		{selector: "all()", terms: nil},
+		{selector: "DNS_RESOLVER == 'github.com'", terms: []Term{{Key: "DNS_RESOLVER", Value: "github.com"}}},
+		{selector: `DNS_RESOLVER=="github.com"`, terms: []Term{{Key: "DNS_RESOLVER", Value: "github.com"}}},
+		{selector: "DNS_RESOLVER in {'a.com','b.com'}", terms: []Term{
+			{Key: "DNS_RESOLVER", Value: "a.com"},
+			{Key: "DNS_RESOLVER", Value: "b.com"},
+		}},
+		{selector: "DNS_RESOLVER in {}", terms: nil},
+		{selector: "DNS_RESOLVER == 'x' && env == 'prod'", terms: []Term{
+			{Key: "DNS_RESOLVER", Value: "x"},
+			{Key: "env", Value: "prod"},
+		}},
+		{selector: "(DNS_RESOLVER == 'a.com' || HTTP_RESOLVER == 'web') && has(app) && global()", terms: []Term{
+			{Key: "DNS_RESOLVER", Value: "a.com"},
+			{Key: "HTTP_RESOLVER", Value: "web"},
+		}},
+		{selector: "DNS_RESOLVER != 'a.com'", terms: []Term{{Key: "DNS_RESOLVER", Value: "a.com", Negated: true}}},
+		{selector: "DNS_RESOLVER not in {'a.com'}", terms: []Term{{Key: "DNS_RESOLVER", Value: "a.com", Negated: true}}},
+		{selector: "!(DNS_RESOLVER == 'a.com' && !(env != 'prod'))", terms: []Term{
+			{Key: "DNS_RESOLVER", Value: "a.com", Negated: true},
+			{Key: "env", Value: "prod", Negated: true},
+		}},
+		{selector: "!!DNS_RESOLVER == 'a.com'", terms: []Term{{Key: "DNS_RESOLVER", Value: "a.com"}}},
+		{selector: "!has(DNS_RESOLVER) || DNS_RESOLVER starts with 'a' || env ends with 'd' || env contains 'r'", terms: nil},
+	} {
+		terms, err := Parse(tc.selector)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tc.selector, err)
+			continue
+		}
+		if !reflect.DeepEqual(terms, tc.terms) {
+			t.Errorf("Parse(%q) = %+v, expected %+v", tc.selector, terms, tc.terms)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for selector, expected := range map[string]string{
+		"DNS_RESOLVER == 'github.com":       "unterminated string at position 16",
+		"DNS_RESOLVER = 'github.com'":       `unexpected character '=' at position 13`,
+		"DNS_RESOLVER == github.com":        `expected string at position 16, got "github.com"`,
+		"DNS_RESOLVER 'github.com'":         `expected operator after "DNS_RESOLVER" at position 13, got string "github.com"`,
+		"DNS_RESOLVER is 'github.com'":      `unknown operator "is" at position 13`,
+		"DNS_RESOLVER in {'a.com' 'b.com'}": `expected "," or "}" at position 25, got string "b.com"`,
+		"DNS_RESOLVER not {'a.com'}":        `expected "in" at position 17, got "{"`,
+		"DNS_RESOLVER starts 'a'":           `expected "with" at position 20, got string "a"`,
+		"(DNS_RESOLVER == 'a.com'":          `expected ")" at position 24, got end of selector`,
+		"DNS_RESOLVER == 'a.com' env":       `unexpected "env" at position 24`,
+		"DNS_RESOLVER == 'a.com' &&":        "unexpected end of selector at position 26",
+		"any()":                             `unknown function "any" at position 0`,
+	} {
+		_, err := Parse(selector)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, expected error %q", selector, expected)
+			continue
+		}
+		if err.Error() != expected {
+			t.Errorf("Parse(%q) failed with %q, expected %q", selector, err, expected)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherDomain is the label value of the domains which are not exported by DomainFilter
+const OtherDomain = "_other"
+
+// DomainFilter protects the per-domain metrics from the high cardinality.
+// Domains are exported when they match one of the allowlist patterns (all domains if the allowlist is empty)
+// and at most MaxDomains distinct domains are exported at the same time, zero means no limit.
+// When the limit is reached, the domain which was not observed for Expiry is replaced by the new domain
+// and its series are deleted, zero Expiry keeps the first MaxDomains domains
+type DomainFilter struct {
+	// Allowlist is a list of the domain patterns in path.Match format, for example *.example.com
+	Allowlist  []string
+	MaxDomains int
+	Expiry     time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+// NewDomainFilter creates filter for the allowlist patterns, the limit of exported domains and their expiry
+func NewDomainFilter(allowlist []string, maxDomains int, expiry time.Duration) *DomainFilter {
+	return &DomainFilter{
+		Allowlist:  allowlist,
+		MaxDomains: maxDomains,
+		Expiry:     expiry,
+		seen:       map[string]time.Time{},
+		now:        time.Now,
+	}
+}
+
+// Allowed reports whether the domain is exported as the label value
+func (f *DomainFilter) Allowed(domain string) bool {
+	if !f.matches(domain) {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if _, ok := f.seen[domain]; ok {
+		f.seen[domain] = now
+		return true
+	}
+	if f.MaxDomains > 0 && len(f.seen) >= f.MaxDomains {
+		if !f.evict(now) {
+			return false
+		}
+	}
+	f.seen[domain] = now
+	return true
+}
+
+// evict removes the least recently observed domain if it is expired and deletes its series
+func (f *DomainFilter) evict(now time.Time) bool {
+	if f.Expiry <= 0 {
+		return false
+	}
+	oldest := ""
+	for domain, seen := range f.seen {
+		if oldest == "" || seen.Before(f.seen[oldest]) {
+			oldest = domain
+		}
+	}
+	if now.Sub(f.seen[oldest]) < f.Expiry {
+		return false
+	}
+	delete(f.seen, oldest)
+	deleteDomainSeries(oldest)
+	return true
+}
+
+// Label returns the domain or OtherDomain if the domain is not exported
+func (f *DomainFilter) Label(domain string) string {
+	if f.Allowed(domain) {
+		return domain
+	}
+	return OtherDomain
+}
+
+func (f *DomainFilter) matches(domain string) bool {
+	if len(f.Allowlist) == 0 {
+		return true
+	}
+	for _, pattern := range f.Allowlist {
+		if ok, err := path.Match(pattern, domain); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	domainFilterMu sync.RWMutex
+	domainFilter   = NewDomainFilter(nil, 0, 0)
+)
+
+// UseDomainFilter replaces the filter of the per-domain metrics
+func UseDomainFilter(filter *DomainFilter) {
+	domainFilterMu.Lock()
+	defer domainFilterMu.Unlock()
+	domainFilter = filter
+}
+
+func getDomainFilter() *DomainFilter {
+	domainFilterMu.RLock()
+	defer domainFilterMu.RUnlock()
+	return domainFilter
+}
+
+// deleteDomainSeries removes the series of the per-domain metrics of the domain
+func deleteDomainSeries(domain string) {
+	labels := prometheus.Labels{"domain": domain}
+	NetworksetControllerResolveDuration.DeletePartialMatch(labels)
+	NetworksetControllerLastSuccessfulResolve.DeletePartialMatch(labels)
+	NetworksetControllerAddressChurn.DeletePartialMatch(labels)
+}
+
+// ObserveResolve counts the resolve by the result and records its duration and the time of the successful resolve of the domain
+func ObserveResolve(resolver string, domain string, duration time.Duration, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+	NetworksetControllerResolves.WithLabelValues(result).Inc()
+	filter := getDomainFilter()
+	NetworksetControllerResolveDuration.WithLabelValues(resolver, filter.Label(domain)).Observe(duration.Seconds())
+	if err == nil && filter.Allowed(domain) {
+		NetworksetControllerLastSuccessfulResolve.WithLabelValues(resolver, domain).SetToCurrentTime()
+	}
+}
+
+// SetAddresses records the number of networks of the managed networkset, it is not recorded in the dry-run mode
+// because the networks are not written
+func SetAddresses(kind string, namespace string, name string, domain string, count int) {
+	if dryRun.Load() || !getDomainFilter().Allowed(domain) {
+		return
+	}
+	NetworksetControllerAddresses.WithLabelValues(kind, namespace, name).Set(float64(count))
+}
+
+// DeleteAddresses removes the number of networks of the deleted networkset
+func DeleteAddresses(kind string, namespace string, name string) {
+	NetworksetControllerAddresses.DeleteLabelValues(kind, namespace, name)
+}
+
+// AddAddressChurn counts the networks added to and removed from the networksets of the domain
+func AddAddressChurn(resolver string, domain string, added int, removed int) {
+	if dryRun.Load() {
+		return
+	}
+	label := getDomainFilter().Label(domain)
+	if added > 0 {
+		NetworksetControllerAddressChurn.WithLabelValues(resolver, label, "added").Add(float64(added))
+	}
+	if removed > 0 {
+		NetworksetControllerAddressChurn.WithLabelValues(resolver, label, "removed").Add(float64(removed))
+	}
+}
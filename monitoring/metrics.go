@@ -17,207 +17,230 @@ limitations under the License.
 package monitoring
 
 import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
-// MetricDescription is an exported struct that defines the metric description (Name, Help)
-// as a new type named MetricDescription.
+// Kinds of the managed resources, values of the kind label
+const (
+	KindNetworkSet       = "networkset"
+	KindGlobalNetworkSet = "globalnetworkset"
+)
+
+// Operations on the managed resources, values of the operation label
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Results of the resolves, values of the result label
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// MetricDescription is an exported struct that defines the metric description (Name, Help, Type)
+// and the labels of the metric vector.
 type MetricDescription struct {
-	Name string
-	Help string
-	Type string
+	Name    string
+	Help    string
+	Type    string
+	Labels  []string
+	Buckets []float64
 }
 
-// metricsDescription is a map of string keys (metrics) to MetricDescription values (Name, Help).
-var metricDescription = map[string]MetricDescription{
-	"NetworksetControllerResolveFailed": {
-		Name: "networkset_controller_resolve_failed",
-		Help: "Total number of failed resolve attempts.",
-		Type: "Counter",
+// metricDescriptions is the table all metrics of the controller are built from.
+var metricDescriptions = []MetricDescription{
+	{
+		Name:   "networkset_controller_operations_total",
+		Help:   "Total number of successful operations on the managed networksets by kind and operation.",
+		Type:   "Counter",
+		Labels: []string{"kind", "operation"},
 	},
-	"NetworksetControllerResolveSuccesful": {
-		Name: "networkset_controller_resolve_succesful",
-		Help: "Total number of succesful resolve attempts.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_operation_failures_total",
+		Help:   "Total number of failed operations on the managed networksets by kind and operation.",
+		Type:   "Counter",
+		Labels: []string{"kind", "operation"},
 	},
-	"NetworksetControllerNetworksetCreated": {
-		Name: "networkset_controller_networkset_created",
-		Help: "Total number of successful created networksets.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_resolves_total",
+		Help:   "Total number of resolve attempts by result.",
+		Type:   "Counter",
+		Labels: []string{"result"},
 	},
-	"NetworksetControllerNetworksetCreationFailed": {
-		Name: "networkset_controller_networkset_failed",
-		Help: "Total number of failed creating networksets.",
+	{
+		Name: "networkset_controller_resolve_cache_hits_total",
+		Help: "Total number of resolves served from the cache or joined to the resolve in progress.",
 		Type: "Counter",
 	},
-	"NetworksetControllerGlobalNetworksetCreated": {
-		Name: "networkset_controller_globalnetworkset_created",
-		Help: "Total number of successful created globalnetworksets.",
+	{
+		Name: "networkset_controller_resolve_cache_misses_total",
+		Help: "Total number of resolves not found in the cache.",
 		Type: "Counter",
 	},
-	"NetworksetControllerGlobalNetworksetFailed": {
-		Name: "networkset_controller_globalnetworkset_failed",
-		Help: "Total number of failed creating globalnetworksets.",
-		Type: "Counter",
+	{
+		Name: "networkset_controller_resolve_cache_entries",
+		Help: "Number of resolved values in the cache.",
+		Type: "Gauge",
 	},
-	"NetworksetControllerNetworksetUpdated": {
-		Name: "networkset_controller_networkset_updated",
-		Help: "Total number of successful updated networksets.",
-		Type: "Counter",
+	{
+		Name:    "networkset_controller_resolve_duration_seconds",
+		Help:    "Duration of the resolves by the resolver label and domain.",
+		Type:    "Histogram",
+		Labels:  []string{"resolver", "domain"},
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
 	},
-	"NetworksetControllerNetworksetUpdateFailed": {
-		Name: "networkset_controller_networkset_update_failed",
-		Help: "Total number of failed updating networksets.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_last_successful_resolve_timestamp_seconds",
+		Help:   "Time of the last successful resolve by the resolver label and domain.",
+		Type:   "Gauge",
+		Labels: []string{"resolver", "domain"},
 	},
-	"NetworksetControllerGlobalNetworksetUpdated": {
-		Name: "networkset_controller_globalnetworkset_updated",
-		Help: "Total number of successful updated globalnetworksets.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_addresses",
+		Help:   "Number of networks of the managed networkset.",
+		Type:   "Gauge",
+		Labels: []string{"kind", "namespace", "name"},
 	},
-	"NetworksetControllerGlobalNetworksetUpdateFailed": {
-		Name: "networkset_controller_globalnetworkset_update_failed",
-		Help: "Total number of failed updating globalnetworksets.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_address_churn_total",
+		Help:   "Total number of networks added to and removed from the networksets by the resolver label and domain.",
+		Type:   "Counter",
+		Labels: []string{"resolver", "domain", "change"},
 	},
-	"NetworksetControllerNetworksetDeleted": {
-		Name: "networkset_controller_networkset_deleted",
-		Help: "Total number of successful deleted networksets.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_dry_run_operations_total",
+		Help:   "Total number of operations on the managed resources skipped in the dry-run mode by kind and operation.",
+		Type:   "Counter",
+		Labels: []string{"kind", "operation"},
 	},
-	"NetworksetControllerNetworksetDeletionFailed": {
-		Name: "networkset_controller_networkset_deletion_failed",
-		Help: "Total number of failed deletion networksets.",
-		Type: "Counter",
-	},
-	"NetworksetControllerGlobalNetworksetDeleted": {
-		Name: "networkset_controller_globalnetworkset_deleted",
-		Help: "Total number of successful deleted globalnetworksets.",
-		Type: "Counter",
-	},
-	"NetworksetControllerGlobalNetworksetDeletionFailed": {
-		Name: "networkset_controller_globalnetworkset_deletion_failed",
-		Help: "Total number of failed deletion globalnetworksets.",
-		Type: "Counter",
+	{
+		Name:   "networkset_controller_dry_run_network_changes_total",
+		Help:   "Total number of networks which would be added to and removed from the managed networksets in the dry-run mode.",
+		Type:   "Counter",
+		Labels: []string{"kind", "change"},
 	},
 }
 
+// collectors are the metrics built from the table by name
+var collectors = map[string]prometheus.Collector{}
+
 var (
-	// MemcachedDeploymentSizeUndesiredCountTotal will count how many times was required
-	// to perform the operation to ensure that the number of replicas on the cluster
-	// is the same as the quantity desired and specified via the custom resource size spec.
-	NetworksetControllerResolveFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerResolveFailed"].Name,
-			Help: metricDescription["NetworksetControllerResolveFailed"].Help,
-		},
-	)
-	NetworksetControllerResolveSuccesful = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerResolveSuccesful"].Name,
-			Help: metricDescription["NetworksetControllerResolveSucessful"].Help,
-		},
-	)
-	NetworksetControllerNetworksetCreated = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerNetworksetCreated"].Name,
-			Help: metricDescription["NetworksetControllerNetworksetCreated"].Help,
-		},
-	)
-	NetworksetControllerNetworksetCreationFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerNetworksetCreationFailed"].Name,
-			Help: metricDescription["NetworksetControllerNetworksetCreationFailed"].Help,
-		},
-	)
-	NetworksetControllerGlobalNetworksetCreated = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerGlobalNetworksetCreated"].Name,
-			Help: metricDescription["NetworksetControllerGlobalNetworksetCreated"].Help,
-		},
-	)
-	NetworksetControllerGlobalNetworksetCreationFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerGlobalNetworksetCreationFailed"].Name,
-			Help: metricDescription["NetworksetControllerGlobalNetworksetCreationFailed"].Help,
-		},
-	)
-	NetworksetControllerNetworksetUpdated = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerNetworksetUpdated"].Name,
-			Help: metricDescription["NetworksetControllerNetworksetUpdated"].Help,
-		},
-	)
-	NetworksetControllerNetworksetUpdateFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerNetworksetUpdateFailed"].Name,
-			Help: metricDescription["NetworksetControllerNetworksetUpdateFailed"].Help,
-		},
-	)
-	NetworksetControllerGlobalNetworksetUpdated = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerGlobalNetworksetUpdated"].Name,
-			Help: metricDescription["NetworksetControllerGlobalNetworksetUpdated"].Help,
-		},
-	)
-	NetworksetControllerGlobalNetworksetUpdateFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerGlobalNetworksetUpdateFailed"].Name,
-			Help: metricDescription["NetworksetControllerGlobalNetworksetUpdateFailed"].Help,
-		},
-	)
-	NetworksetControllerNetworksetDeleted = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerNetworksetDeleted"].Name,
-			Help: metricDescription["NetworksetControllerNetworksetDeleted"].Help,
-		},
-	)
-	NetworksetControllerNetworksetDeletionFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerNetworksetDeletionFailed"].Name,
-			Help: metricDescription["NetworksetControllerNetworksetDeletionFailed"].Help,
-		},
-	)
-	NetworksetControllerGlobalNetworksetDeleted = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerGlobalNetworksetDeleted"].Name,
-			Help: metricDescription["NetworksetControllerGlobalNetworksetDeleted"].Help,
-		},
-	)
-	NetworksetControllerGlobalNetworksetDeletionFailed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricDescription["NetworksetControllerGlobalNetworksetDeletionFailed"].Name,
-			Help: metricDescription["NetworksetControllerGlobalNetworksetDeletionFailed"].Help,
-		},
-	)
+	NetworksetControllerOperations            = newCollector("networkset_controller_operations_total").(*prometheus.CounterVec)
+	NetworksetControllerOperationFailures     = newCollector("networkset_controller_operation_failures_total").(*prometheus.CounterVec)
+	NetworksetControllerResolves              = newCollector("networkset_controller_resolves_total").(*prometheus.CounterVec)
+	NetworksetControllerResolveCacheHits      = newCollector("networkset_controller_resolve_cache_hits_total").(prometheus.Counter)
+	NetworksetControllerResolveCacheMisses    = newCollector("networkset_controller_resolve_cache_misses_total").(prometheus.Counter)
+	NetworksetControllerResolveCacheEntries   = newCollector("networkset_controller_resolve_cache_entries").(prometheus.Gauge)
+	NetworksetControllerResolveDuration       = newCollector("networkset_controller_resolve_duration_seconds").(*prometheus.HistogramVec)
+	NetworksetControllerLastSuccessfulResolve = newCollector("networkset_controller_last_successful_resolve_timestamp_seconds").(*prometheus.GaugeVec)
+	NetworksetControllerAddresses             = newCollector("networkset_controller_addresses").(*prometheus.GaugeVec)
+	NetworksetControllerAddressChurn          = newCollector("networkset_controller_address_churn_total").(*prometheus.CounterVec)
+	NetworksetControllerDryRunOperations      = newCollector("networkset_controller_dry_run_operations_total").(*prometheus.CounterVec)
+	NetworksetControllerDryRunNetworkChanges  = newCollector("networkset_controller_dry_run_network_changes_total").(*prometheus.CounterVec)
 )
 
+// newCollector builds the metric described in the table, it panics if the metric is not described
+func newCollector(name string) prometheus.Collector {
+	index := slices.IndexFunc(metricDescriptions, func(description MetricDescription) bool {
+		return description.Name == name
+	})
+	if index < 0 {
+		panic(fmt.Sprintf("metric %s is not described", name))
+	}
+	description := metricDescriptions[index]
+
+	var collector prometheus.Collector
+	switch {
+	case description.Type == "Counter" && len(description.Labels) == 0:
+		collector = prometheus.NewCounter(prometheus.CounterOpts{Name: description.Name, Help: description.Help})
+	case description.Type == "Counter":
+		collector = prometheus.NewCounterVec(prometheus.CounterOpts{Name: description.Name, Help: description.Help}, description.Labels)
+	case description.Type == "Gauge" && len(description.Labels) == 0:
+		collector = prometheus.NewGauge(prometheus.GaugeOpts{Name: description.Name, Help: description.Help})
+	case description.Type == "Gauge":
+		collector = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: description.Name, Help: description.Help}, description.Labels)
+	case description.Type == "Histogram":
+		collector = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    description.Name,
+			Help:    description.Help,
+			Buckets: description.Buckets,
+		}, description.Labels)
+	default:
+		panic(fmt.Sprintf("metric %s has unknown type %s", name, description.Type))
+	}
+	collectors[name] = collector
+	return collector
+}
+
+// dryRun disables the counters of the written changes, the skipped writes are counted by DryRunOperation only
+var dryRun atomic.Bool
+
+// SetDryRun enables the dry-run mode of the metrics
+func SetDryRun(enabled bool) {
+	dryRun.Store(enabled)
+}
+
+// OperationSucceeded counts the successful operation on the managed networkset of the kind
+func OperationSucceeded(kind string, operation string) {
+	if dryRun.Load() {
+		return
+	}
+	NetworksetControllerOperations.WithLabelValues(kind, operation).Inc()
+}
+
+// OperationFailed counts the failed operation on the managed networkset of the kind
+func OperationFailed(kind string, operation string) {
+	if dryRun.Load() {
+		return
+	}
+	NetworksetControllerOperationFailures.WithLabelValues(kind, operation).Inc()
+}
+
+// DryRunOperation counts the operation skipped in the dry-run mode and the networks it would add and remove
+func DryRunOperation(kind string, operation string, added int, removed int) {
+	NetworksetControllerDryRunOperations.WithLabelValues(kind, operation).Inc()
+	if added > 0 {
+		NetworksetControllerDryRunNetworkChanges.WithLabelValues(kind, "added").Add(float64(added))
+	}
+	if removed > 0 {
+		NetworksetControllerDryRunNetworkChanges.WithLabelValues(kind, "removed").Add(float64(removed))
+	}
+}
+
 // RegisterMetrics will register metrics with the global prometheus registry
 func RegisterMetrics() {
-	metrics.Registry.MustRegister(NetworksetControllerResolveFailed)
-	metrics.Registry.MustRegister(NetworksetControllerResolveSuccesful)
-	metrics.Registry.MustRegister(NetworksetControllerNetworksetCreated)
-	metrics.Registry.MustRegister(NetworksetControllerNetworksetCreationFailed)
-	metrics.Registry.MustRegister(NetworksetControllerGlobalNetworksetCreated)
-	//metrics.Registry.MustRegister(NetworksetControllerGlobalNetworksetCreationFailed)
-	metrics.Registry.MustRegister(NetworksetControllerNetworksetUpdated)
-	metrics.Registry.MustRegister(NetworksetControllerNetworksetUpdateFailed)
-	metrics.Registry.MustRegister(NetworksetControllerGlobalNetworksetUpdated)
-	metrics.Registry.MustRegister(NetworksetControllerGlobalNetworksetUpdateFailed)
-	metrics.Registry.MustRegister(NetworksetControllerNetworksetDeleted)
-	metrics.Registry.MustRegister(NetworksetControllerNetworksetDeletionFailed)
-	metrics.Registry.MustRegister(NetworksetControllerGlobalNetworksetDeleted)
-	metrics.Registry.MustRegister(NetworksetControllerGlobalNetworksetDeletionFailed)
+	register(metrics.Registry)
 }
 
-// ListMetrics will create a slice with the metrics available in metricDescription
-func ListMetrics() []MetricDescription {
-	v := make([]MetricDescription, 0, len(metricDescription))
-	// Insert value (Name, Help) for each metric
-	for _, value := range metricDescription {
-		v = append(v, value)
+// register registers all described metrics, the counters of every kind, operation and result
+// are exported before the first increment
+func register(registerer prometheus.Registerer) {
+	for _, description := range metricDescriptions {
+		registerer.MustRegister(collectors[description.Name])
+	}
+	for _, kind := range []string{KindNetworkSet, KindGlobalNetworkSet} {
+		for _, operation := range []string{OperationCreate, OperationUpdate, OperationDelete} {
+			NetworksetControllerOperations.WithLabelValues(kind, operation)
+			NetworksetControllerOperationFailures.WithLabelValues(kind, operation)
+			NetworksetControllerDryRunOperations.WithLabelValues(kind, operation)
+		}
+		for _, change := range []string{"added", "removed"} {
+			NetworksetControllerDryRunNetworkChanges.WithLabelValues(kind, change)
+		}
+	}
+	for _, result := range []string{ResultSuccess, ResultFailure} {
+		NetworksetControllerResolves.WithLabelValues(result)
 	}
+}
 
-	return v
+// ListMetrics will create a slice with the metrics available in metricDescriptions
+func ListMetrics() []MetricDescription {
+	return slices.Clone(metricDescriptions)
 }
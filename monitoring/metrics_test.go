@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsRegisteredAndExported(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	register(registry)
+
+	// vectors without the fixed label values are exported after the first observation
+	ObserveResolve("DNS_RESOLVER", "example.com", 10*time.Millisecond, nil)
+	SetAddresses(KindNetworkSet, "default", "policy-example-com", "example.com", 2)
+	AddAddressChurn("DNS_RESOLVER", "example.com", 2, 1)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("cannot gather metrics: %v", err)
+	}
+	exported := map[string]bool{}
+	for _, family := range families {
+		exported[family.GetName()] = true
+		index := slices.IndexFunc(ListMetrics(), func(description MetricDescription) bool {
+			return description.Name == family.GetName()
+		})
+		if index < 0 {
+			t.Errorf("metric %s is exported but not listed", family.GetName())
+			continue
+		}
+		description := ListMetrics()[index]
+		if family.GetHelp() != description.Help {
+			t.Errorf("metric %s has help %q, listed %q", description.Name, family.GetHelp(), description.Help)
+		}
+		if family.GetType().String() != strings.ToUpper(description.Type) {
+			t.Errorf("metric %s has type %s, listed %s", description.Name, family.GetType(), description.Type)
+		}
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName())
+			}
+			expected := slices.Clone(description.Labels)
+			slices.Sort(expected)
+			if !slices.Equal(labels, expected) {
+				t.Errorf("metric %s has labels %v, listed %v", description.Name, labels, description.Labels)
+			}
+		}
+	}
+
+	for _, description := range ListMetrics() {
+		if description.Name == "" || description.Help == "" {
+			t.Errorf("metric %q has no name or help", description.Name)
+		}
+		if !exported[description.Name] {
+			t.Errorf("metric %s is listed but not exported", description.Name)
+		}
+	}
+}
+
+func TestOperationCounters(t *testing.T) {
+	for _, kind := range []string{KindNetworkSet, KindGlobalNetworkSet} {
+		for _, operation := range []string{OperationCreate, OperationUpdate, OperationDelete} {
+			before := testutil.ToFloat64(NetworksetControllerOperationFailures.WithLabelValues(kind, operation))
+			OperationFailed(kind, operation)
+			if after := testutil.ToFloat64(NetworksetControllerOperationFailures.WithLabelValues(kind, operation)); after != before+1 {
+				t.Errorf("failures of %s %s = %v, expected %v", operation, kind, after, before+1)
+			}
+		}
+	}
+}
+
+func TestDryRunCounters(t *testing.T) {
+	SetDryRun(true)
+	defer SetDryRun(false)
+	before := testutil.ToFloat64(NetworksetControllerOperations.WithLabelValues(KindNetworkSet, OperationUpdate))
+	dryRunBefore := testutil.ToFloat64(NetworksetControllerDryRunOperations.WithLabelValues(KindNetworkSet, OperationUpdate))
+	OperationSucceeded(KindNetworkSet, OperationUpdate)
+	DryRunOperation(KindNetworkSet, OperationUpdate, 1, 0)
+	SetAddresses(KindNetworkSet, "default", "dry-run-example-com", "example.com", 2)
+	if after := testutil.ToFloat64(NetworksetControllerOperations.WithLabelValues(KindNetworkSet, OperationUpdate)); after != before {
+		t.Errorf("operations in dry-run mode = %v, expected %v", after, before)
+	}
+	if after := testutil.ToFloat64(NetworksetControllerDryRunOperations.WithLabelValues(KindNetworkSet, OperationUpdate)); after != dryRunBefore+1 {
+		t.Errorf("dry-run operations = %v, expected %v", after, dryRunBefore+1)
+	}
+	if NetworksetControllerAddresses.DeleteLabelValues(KindNetworkSet, "default", "dry-run-example-com") {
+		t.Errorf("addresses are recorded in dry-run mode")
+	}
+}
+
+func TestDomainFilter(t *testing.T) {
+	filter := NewDomainFilter([]string{"*.example.com"}, 2, 0)
+	for domain, expected := range map[string]string{
+		"a.example.com": "a.example.com",
+		"example.org":   OtherDomain,
+	} {
+		if label := filter.Label(domain); label != expected {
+			t.Errorf("label of %s = %s, expected %s", domain, label, expected)
+		}
+	}
+	filter.Label("b.example.com")
+	if label := filter.Label("c.example.com"); label != OtherDomain {
+		t.Errorf("label over the limit = %s, expected %s", label, OtherDomain)
+	}
+	if label := filter.Label("a.example.com"); label != "a.example.com" {
+		t.Errorf("label of seen domain = %s, expected a.example.com", label)
+	}
+}
+
+func TestDomainFilterExpiry(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	filter := NewDomainFilter(nil, 2, 10*time.Minute)
+	filter.now = func() time.Time { return now }
+	UseDomainFilter(filter)
+	defer UseDomainFilter(NewDomainFilter(nil, 0, 0))
+
+	ObserveResolve("DNS_RESOLVER", "a.expiry.com", time.Millisecond, nil)
+	now = now.Add(5 * time.Minute)
+	ObserveResolve("DNS_RESOLVER", "b.expiry.com", time.Millisecond, nil)
+	if label := filter.Label("c.expiry.com"); label != OtherDomain {
+		t.Errorf("label over the limit before expiry = %s, expected %s", label, OtherDomain)
+	}
+
+	now = now.Add(6 * time.Minute)
+	if label := filter.Label("c.expiry.com"); label != "c.expiry.com" {
+		t.Errorf("label replacing the expired domain = %s, expected c.expiry.com", label)
+	}
+	// the delete reports the series which were left by the filter
+	for domain, expected := range map[string]int{"a.expiry.com": 0, "b.expiry.com": 1} {
+		if count := NetworksetControllerLastSuccessfulResolve.DeletePartialMatch(prometheus.Labels{"domain": domain}); count != expected {
+			t.Errorf("series of %s = %d, expected %d", domain, count, expected)
+		}
+	}
+}